@@ -0,0 +1,93 @@
+package main
+
+import (
+  "net/http"
+  "strconv"
+  "time"
+
+  "github.com/endless/shelld/internal/shell"
+)
+
+// historyResponse describes a command in the history; the output is only included when a single
+// command is requested
+type historyResponse struct {
+  ID         uint64     `json:"id"`
+  Command    string     `json:"command"`
+  Status     string     `json:"status"`
+  Started    *time.Time `json:"started,omitempty"`
+  Ended      *time.Time `json:"ended,omitempty"`
+  DurationMs int64      `json:"duration_ms"`
+  ExitCode   *int       `json:"exit_code"`
+  Truncated  bool       `json:"truncated"`
+  Output     *string    `json:"output,omitempty"`
+  Stderr     *string    `json:"stderr,omitempty"`
+}
+
+func ( server *serverInstance ) handleHistory( writer http.ResponseWriter,
+                                               request *http.Request ) {
+  entries := server.requestShell( request ).History()
+
+  response := make( []historyResponse, 0, len( entries ) )
+  for _, entry := range entries {
+    response = append( response, newHistoryResponse( entry, false ) )
+  }
+  writeJSON( writer, http.StatusOK, response )
+}
+
+func ( server *serverInstance ) handleHistoryEntry( writer http.ResponseWriter,
+                                                    request *http.Request ) {
+  id, err := pathCommandID( request, "command" )
+  if err != nil {
+    writeError( writer, request, http.StatusNotFound, errorCodeNotInHistory, err.Error(), "" )
+    return
+  }
+  entry, err := server.requestShell( request ).HistoryEntry( id )
+  if err != nil {
+    writeError( writer, request, http.StatusNotFound, errorCodeNotInHistory, err.Error(), "" )
+    return
+  }
+  writeJSON( writer, http.StatusOK, newHistoryResponse( entry, true ) )
+}
+
+// pathCommandID parses the command ID in the named path segment; an ID that cannot be parsed is not
+// in the history
+func pathCommandID( request *http.Request, name string ) ( uint64, error ) {
+  id, err := strconv.ParseUint( request.PathValue( name ), 10, 64 )
+  if err != nil {
+    return 0, shell.ErrNotInHistory
+  }
+  return id, nil
+}
+
+// newHistoryResponse describes the command, with its output if withOutput is set
+func newHistoryResponse( entry shell.HistoryEntry, withOutput bool ) historyResponse {
+  response := historyResponse{
+    ID:      entry.ID,
+    Command: entry.Command,
+    Status:  string( entry.Status ),
+  }
+  if !entry.Started.IsZero() {
+    response.Started = &entry.Started
+  }
+  if entry.Status == shell.CommandQueued {
+    return response
+  }
+  if entry.Status == shell.CommandRunning {
+    response.DurationMs = time.Since( entry.Started ).Milliseconds()
+    return response
+  }
+
+  response.Ended = &entry.Ended
+  response.DurationMs = entry.Duration.Milliseconds()
+  if entry.Result != nil {
+    response.ExitCode = &entry.Result.ExitCode
+    response.Truncated = entry.Result.Truncated
+    if withOutput {
+      response.Output = &entry.Result.Output
+      if entry.Result.SeparateStderr {
+        response.Stderr = &entry.Result.Stderr
+      }
+    }
+  }
+  return response
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+  "errors"
+  "net/http"
+  "strconv"
+  "strings"
+
+  "github.com/endless/shelld/internal/shell"
+)
+
+// submitExecute starts a command without waiting for it and responds with its job ID; the job is
+// the command's record in the history
+func ( server *serverInstance ) submitExecute( writer http.ResponseWriter,
+                                               request *http.Request,
+                                               command string,
+                                               options shell.Options ) {
+  target := server.requestShell( request )
+  id, err := target.Submit( command, options )
+  if err != nil {
+    server.writeExecuteError( writer, request, err )
+    return
+  }
+
+  jobID := strconv.FormatUint( id, 10 )
+  writer.Header().Set( "X-Job-ID", jobID )
+  writer.Header().Set( "Location", strings.TrimSuffix( request.URL.Path, "/execute" )+"/jobs/"+jobID )
+  if wantsJSON( request ) {
+    writeJSON( writer, http.StatusAccepted, executeResponse{ JobID: id, State: string( target.State() ) } )
+    return
+  }
+  writer.WriteHeader( http.StatusAccepted )
+  writer.Write( []byte( jobID + "\n" ) )
+}
+
+func ( server *serverInstance ) handleJob( writer http.ResponseWriter,
+                                           request *http.Request ) {
+  id, err := pathCommandID( request, "job" )
+  if err != nil {
+    writeError( writer, request, http.StatusNotFound, errorCodeNotInHistory, err.Error(), "" )
+    return
+  }
+  entry, err := server.requestShell( request ).HistoryEntry( id )
+  if err != nil {
+    writeError( writer, request, http.StatusNotFound, errorCodeNotInHistory, err.Error(), "" )
+    return
+  }
+  writeJSON( writer, http.StatusOK, newHistoryResponse( entry, true ) )
+}
+
+func ( server *serverInstance ) handleDeleteJob( writer http.ResponseWriter,
+                                                 request *http.Request ) {
+  id, err := pathCommandID( request, "job" )
+  if err != nil {
+    writeError( writer, request, http.StatusNotFound, errorCodeNotInHistory, err.Error(), "" )
+    return
+  }
+
+  target := server.requestShell( request )
+  err = target.KillCommand( id )
+  if errors.Is( err, shell.ErrNotInHistory ) {
+    writeError( writer, request, http.StatusNotFound, errorCodeNotInHistory, err.Error(), "" )
+    return
+  }
+  if errors.Is( err, shell.ErrCommandFinished ) {
+    writeError( writer, request, http.StatusConflict, errorCodeNotExecuting, err.Error(), string( target.State() ) )
+    return
+  }
+  if err != nil {
+    writeError( writer, request, http.StatusInternalServerError, errorCodeInternal,
+                "The job could not be killed.", string( target.State() ) )
+    return
+  }
+
+  writer.WriteHeader( http.StatusOK )
+}
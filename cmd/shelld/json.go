@@ -0,0 +1,201 @@
+package main
+
+import (
+  "encoding/json"
+  "mime"
+  "net/http"
+  "strings"
+
+  "github.com/endless/shelld/internal/shell"
+)
+
+// machine readable error codes returned in JSON responses
+const (
+  errorCodeInvalidRequest  = "invalid_request"
+  errorCodeEmptyCommand    = "empty_command"
+  errorCodeInvalidTimeout  = "invalid_timeout"
+  errorCodeInvalidOptions  = "invalid_options"
+  errorCodeUnauthorized    = "unauthorized"
+  errorCodeNotLocked       = "not_locked"
+  errorCodeLocked          = "locked"
+  errorCodeBusy            = "busy"
+  errorCodeAttached        = "attached"
+  errorCodeUnrecoverable   = "unrecoverable"
+  errorCodeRestarted       = "restarted"
+  errorCodeTimeout         = "timeout"
+  errorCodeNoCommand       = "no_command"
+  errorCodeInvalidRange    = "invalid_range"
+  errorCodeNotCurrent      = "not_current"
+  errorCodeNotInHistory    = "not_in_history"
+  errorCodeCancelled       = "cancelled"
+  errorCodeNotExecuting    = "not_executing"
+  errorCodeKillFailed      = "kill_failed"
+  errorCodeProcessNotFound = "process_not_found"
+  errorCodeProcessExists   = "process_exists"
+  errorCodeSessionNotFound = "session_not_found"
+  errorCodeSessionLimit    = "session_limit"
+  errorCodeInternal        = "internal_error"
+)
+
+// errorCodeForState returns the error code for a request rejected because of the shell state
+func errorCodeForState( state shell.State ) string {
+  switch state {
+  case shell.StateAvailable:
+    return errorCodeNotLocked
+  case shell.StateExecuting:
+    return errorCodeBusy
+  case shell.StateAttached:
+    return errorCodeAttached
+  case shell.StateUnrecoverable:
+    return errorCodeUnrecoverable
+  }
+  return errorCodeInternal
+}
+
+// executeRequest is the body of a JSON /execute request
+type executeRequest struct {
+  Command          string            `json:"command"`
+  Timeout          string            `json:"timeout"`
+  Environment      map[string]string `json:"env"`
+  WorkingDirectory string            `json:"cwd"`
+  SeparateStderr   bool              `json:"separate_stderr"`
+  ExactOutput      *bool             `json:"exact_output"`
+  OutputFilter     string            `json:"output_filter"`
+}
+
+// inputRequest is the body of a JSON /input request
+type inputRequest struct {
+  Data    string `json:"data"`
+  Newline bool   `json:"newline"`
+  EOF     bool   `json:"eof"`
+}
+
+// executeResponse is the body of a JSON /execute response
+type executeResponse struct {
+  JobID         uint64  `json:"job_id"`
+  Output        string  `json:"output"`
+  Stderr        *string `json:"stderr,omitempty"`
+  ExitCode      *int    `json:"exit_code"`
+  DurationMs    int64   `json:"duration_ms"`
+  State         string  `json:"state"`
+  AwaitingInput bool    `json:"awaiting_input"`
+  Truncated     bool    `json:"truncated"`
+  Error         string  `json:"error,omitempty"`
+  Message       string  `json:"message,omitempty"`
+}
+
+// stateResponse is the body of a JSON /state response
+type stateResponse struct {
+  State         string            `json:"state"`
+  AwaitingInput bool              `json:"awaiting_input"`
+  PID           int               `json:"pid,omitempty"`
+  Cwd           string            `json:"cwd,omitempty"`
+  Environment   map[string]string `json:"env,omitempty"`
+  Command       *commandState     `json:"command,omitempty"`
+  LastJobID     uint64            `json:"last_job_id,omitempty"`
+  LastExitCode  *int              `json:"last_exit_code,omitempty"`
+  Restarts      int               `json:"restarts"`
+  Limits        *limitsState      `json:"limits,omitempty"`
+}
+
+// commandState describes the running command in a JSON /state response
+type commandState struct {
+  JobID     uint64 `json:"job_id"`
+  Command   string `json:"command"`
+  ElapsedMs int64  `json:"elapsed_ms"`
+}
+
+// limitsState describes the cgroup of the shell in a JSON /state response; a maximum of zero is not
+// limited
+type limitsState struct {
+  MemoryCurrent  int64   `json:"memory_current_bytes"`
+  MemoryMax      int64   `json:"memory_max_bytes"`
+  OOMKills       int64   `json:"oom_kills"`
+  CPUMax         float64 `json:"cpu_max"`
+  CPUThrottled   int64   `json:"cpu_throttled_periods"`
+  CPUThrottledMs int64   `json:"cpu_throttled_ms"`
+  PIDsCurrent    int64   `json:"pids_current"`
+  PIDsMax        int     `json:"pids_max"`
+  PIDsRejected   int64   `json:"pids_rejected"`
+}
+
+// newStateResponse creates the JSON /state response from a snapshot of the shell
+func newStateResponse( status shell.Status, awaitingInput bool ) stateResponse {
+  response := stateResponse{
+    State:         string( status.State ),
+    AwaitingInput: awaitingInput,
+    PID:           status.PID,
+    Cwd:           status.WorkingDirectory,
+    Environment:   status.Environment,
+    LastJobID:     status.LastCommandID,
+    Restarts:      status.Restarts,
+  }
+  if status.CommandID != 0 {
+    response.Command = &commandState{
+      JobID:     status.CommandID,
+      Command:   status.Command,
+      ElapsedMs: status.Elapsed.Milliseconds(),
+    }
+  }
+  if status.LastCommandID != 0 {
+    response.LastExitCode = &status.LastExitCode
+  }
+  if usage := status.Limits; usage != nil {
+    response.Limits = &limitsState{
+      MemoryCurrent:  usage.MemoryCurrent,
+      MemoryMax:      usage.MemoryMax,
+      OOMKills:       usage.OOMKills,
+      CPUMax:         usage.CPUMax,
+      CPUThrottled:   usage.CPUThrottled,
+      CPUThrottledMs: usage.CPUThrottledTime.Milliseconds(),
+      PIDsCurrent:    usage.PIDsCurrent,
+      PIDsMax:        usage.PIDsMax,
+      PIDsRejected:   usage.PIDsRejected,
+    }
+  }
+  return response
+}
+
+// errorResponse is the body of a JSON error response
+type errorResponse struct {
+  Error   string `json:"error"`
+  Message string `json:"message"`
+  State   string `json:"state,omitempty"`
+}
+
+// isJSONRequest reports whether the request body is JSON
+func isJSONRequest( request *http.Request ) bool {
+  mediaType, _, err := mime.ParseMediaType( request.Header.Get( "Content-Type" ) )
+  return err == nil && mediaType == "application/json"
+}
+
+// wantsJSON reports whether the response should be JSON; a JSON request gets a JSON response
+// unless the caller asks otherwise
+func wantsJSON( request *http.Request ) bool {
+  accept := request.Header.Get( "Accept" )
+  if strings.Contains( accept, "application/json" ) {
+    return true
+  }
+  return isJSONRequest( request ) && ( accept == "" || strings.Contains( accept, "*/*" ) )
+}
+
+// writeJSON writes the value as a JSON response with the given status
+func writeJSON( writer http.ResponseWriter, status int, value any ) {
+  writer.Header().Set( "Content-Type", "application/json" )
+  writer.WriteHeader( status )
+  json.NewEncoder( writer ).Encode( value )
+}
+
+// writeError writes an error as JSON or plain text depending on what the caller negotiated
+func writeError( writer http.ResponseWriter,
+                 request *http.Request,
+                 status int,
+                 code string,
+                 message string,
+                 state string ) {
+  if wantsJSON( request ) {
+    writeJSON( writer, status, errorResponse{ Error: code, Message: message, State: state } )
+    return
+  }
+  http.Error( writer, message, status )
+}
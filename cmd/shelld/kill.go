@@ -0,0 +1,54 @@
+package main
+
+import (
+  "errors"
+  "net/http"
+  "syscall"
+
+  "github.com/endless/shelld/internal/shell"
+)
+
+// killResponse is the body of a JSON /kill?escalate=true response
+type killResponse struct {
+  Signal string `json:"signal"`
+  State  string `json:"state"`
+}
+
+// signalNames maps the signals of an escalating kill to the names reported to the caller
+var signalNames = map[syscall.Signal]string{
+  syscall.SIGINT:  "SIGINT",
+  syscall.SIGTERM: "SIGTERM",
+  syscall.SIGKILL: "SIGKILL",
+}
+
+// handleKillEscalating stops the running command, sending SIGTERM and then SIGKILL to its process
+// group when the interrupt does not stop it within the kill grace period, and reports the signal
+// that stopped it
+func ( server *serverInstance ) handleKillEscalating( writer http.ResponseWriter,
+                                                      request *http.Request ) {
+  target := server.requestShell( request )
+  signal, err := target.KillEscalating()
+  if errors.Is( err, shell.ErrCommandFinished ) {
+    writeError( writer, request, http.StatusConflict, errorCodeNotExecuting,
+                "There is no running command to kill.", string( target.State() ) )
+    return
+  }
+  if errors.Is( err, shell.ErrKillFailed ) {
+    writeError( writer, request, http.StatusInternalServerError, errorCodeKillFailed, err.Error(),
+                string( target.State() ) )
+    return
+  }
+  if err != nil {
+    writeError( writer, request, http.StatusInternalServerError, errorCodeInternal,
+                "The command could not be killed.", string( target.State() ) )
+    return
+  }
+
+  response := killResponse{ Signal: signalNames[signal], State: string( target.State() ) }
+  writer.Header().Set( "X-Kill-Signal", response.Signal )
+  if wantsJSON( request ) {
+    writeJSON( writer, http.StatusOK, response )
+    return
+  }
+  writer.WriteHeader( http.StatusOK )
+}
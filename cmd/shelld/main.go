@@ -2,6 +2,8 @@ package main
 
 import (
   "context"
+  "encoding/json"
+  "errors"
   "flag"
   "fmt"
   "io"
@@ -10,18 +12,22 @@ import (
   "net/http"
   "os"
   "os/signal"
+  "strconv"
+  "strings"
   "sync"
   "syscall"
   "time"
 
   "github.com/endless/shelld/internal/config"
   "github.com/endless/shelld/internal/lifecycle"
+  "github.com/endless/shelld/internal/session"
   "github.com/endless/shelld/internal/shell"
 )
 
 type serverInstance struct {
   cfg           *config.Config
   shell         *shell.Shell
+  sessions      *session.Manager
   hooks         *lifecycle.Hooks
   logger        *slog.Logger
   lastActivity  time.Time
@@ -53,20 +59,62 @@ func main() {
     os.Exit( 1 )
   }
 
+  shellConfig := shell.Config{
+    Command:          cfg.Shell.Command,
+    WorkingDirectory: cfg.Shell.WorkingDirectory,
+    KillGracePeriod:  cfg.Timeout.KillDuration,
+    OutputLimits:     shell.OutputLimits{
+      MaximumBuffered: cfg.Shell.MaximumBuffered,
+      MaximumReturned: cfg.Shell.MaximumReturned,
+    },
+    HistorySize:      *cfg.Shell.HistorySize,
+    QueueDepth:       cfg.Shell.QueueDepth,
+    RestartPolicy:    shell.RestartPolicy{
+      Automatic:  cfg.Shell.AutoRestart,
+      InitScript: cfg.Shell.InitScript,
+    },
+    StateVariables:   cfg.Shell.StateVariables,
+    Limits:           shell.Limits{
+      CgroupParent: cfg.Limits.CgroupParent,
+      MemoryMax:    cfg.Limits.MemoryMax,
+      CPUMax:       cfg.Limits.CPUMax,
+      PIDsMax:      cfg.Limits.PIDsMax,
+    },
+    Rlimits:          shell.Rlimits{
+      OpenFiles: cfg.Shell.Rlimits.OpenFiles,
+      FileSize:  cfg.Shell.Rlimits.FileSize,
+      CoreSize:  cfg.Shell.Rlimits.CoreSize,
+      CPUTime:   cfg.Shell.Rlimits.CPUTime,
+      Processes: cfg.Shell.Rlimits.Processes,
+    },
+  }
+
+  // the shell runs as the configured user while the hooks keep the server's privileges
+  if account := cfg.Shell.Credential; account != nil {
+    shellConfig.Credential = &shell.Credential{
+      UID:      account.UID,
+      GID:      account.GID,
+      Groups:   account.Groups,
+      Username: account.Username,
+      Home:     account.Home,
+    }
+  }
+
+  newShell := func() *shell.Shell {
+    return shell.NewShell( shellConfig, logger )
+  }
+
+  hooks := lifecycle.NewHooks(
+    cfg.Hooks.Shell,
+    cfg.Hooks.Lock,
+    cfg.Hooks.Unlock,
+    logger,
+  )
   server := &serverInstance{
-    cfg: cfg,
-    shell: shell.NewShell(
-      cfg.Shell.Command,
-      cfg.Shell.WorkingDirectory,
-      cfg.Timeout.KillDuration,
-      logger,
-    ),
-    hooks: lifecycle.NewHooks(
-      cfg.Hooks.Shell,
-      cfg.Hooks.Lock,
-      cfg.Hooks.Unlock,
-      logger,
-    ),
+    cfg:          cfg,
+    shell:        newShell(),
+    sessions:     session.NewManager( cfg.Server.MaximumSessions, newShell, hooks, logger ),
+    hooks:        hooks,
     logger:       logger,
     lastActivity: time.Now(),
   }
@@ -75,11 +123,51 @@ func main() {
   multiplexer.HandleFunc( "POST /lock", server.setKeyMiddleware( server.handleLock ) )
   multiplexer.HandleFunc( "POST /execute", server.verifyKeyMiddleware( server.handleExecute ) )
   multiplexer.HandleFunc( "POST /kill", server.verifyKeyMiddleware( server.handleKill ) )
+  multiplexer.HandleFunc( "POST /input", server.verifyKeyMiddleware( server.handleInput ) )
   multiplexer.HandleFunc( "POST /unlock", server.verifyKeyMiddleware( server.handleUnlock ) )
   multiplexer.HandleFunc( "GET /output", server.verifyKeyMiddleware( server.handleOutput ) )
+  multiplexer.HandleFunc( "GET /output/stream", server.verifyKeyMiddleware( server.handleOutputStream ) )
+  multiplexer.HandleFunc( "GET /output/full", server.verifyKeyMiddleware( server.handleOutputFull ) )
   multiplexer.HandleFunc( "GET /state", server.verifyKeyMiddleware( server.handleState ) )
+  multiplexer.HandleFunc( "GET /wait", server.verifyKeyMiddleware( server.handleWait ) )
+  multiplexer.HandleFunc( "GET /history", server.verifyKeyMiddleware( server.handleHistory ) )
+  multiplexer.HandleFunc( "GET /history/{command}", server.verifyKeyMiddleware( server.handleHistoryEntry ) )
+  multiplexer.HandleFunc( "GET /jobs/{job}", server.verifyKeyMiddleware( server.handleJob ) )
+  multiplexer.HandleFunc( "DELETE /jobs/{job}", server.verifyKeyMiddleware( server.handleDeleteJob ) )
+  multiplexer.HandleFunc( "POST /processes", server.verifyKeyMiddleware( server.handleStartProcess ) )
+  multiplexer.HandleFunc( "GET /processes", server.verifyKeyMiddleware( server.handleListProcesses ) )
+  multiplexer.HandleFunc( "GET /processes/tree", server.verifyKeyMiddleware( server.handleProcessTree ) )
+  multiplexer.HandleFunc( "GET /processes/{name}", server.verifyKeyMiddleware( server.handleProcess ) )
+  multiplexer.HandleFunc( "GET /processes/{name}/output", server.verifyKeyMiddleware( server.handleProcessOutput ) )
+  multiplexer.HandleFunc( "DELETE /processes/{name}", server.verifyKeyMiddleware( server.handleStopProcess ) )
+  multiplexer.HandleFunc( "GET /terminal", server.verifyKeyMiddleware( server.handleTerminal ) )
   multiplexer.HandleFunc( "GET /health", server.handleHealth )
 
+  // named sessions are shells next to the one locked through /lock; they belong to the locked key
+  // and are closed when the server is unlocked
+  multiplexer.HandleFunc( "POST /sessions", server.verifyKeyMiddleware( server.handleCreateSession ) )
+  multiplexer.HandleFunc( "GET /sessions", server.verifyKeyMiddleware( server.handleListSessions ) )
+  multiplexer.HandleFunc( "DELETE /sessions/{id}", server.sessionMiddleware( server.handleDeleteSession ) )
+  multiplexer.HandleFunc( "POST /sessions/{id}/execute", server.sessionMiddleware( server.handleExecute ) )
+  multiplexer.HandleFunc( "POST /sessions/{id}/kill", server.sessionMiddleware( server.handleKill ) )
+  multiplexer.HandleFunc( "POST /sessions/{id}/input", server.sessionMiddleware( server.handleInput ) )
+  multiplexer.HandleFunc( "GET /sessions/{id}/output", server.sessionMiddleware( server.handleOutput ) )
+  multiplexer.HandleFunc( "GET /sessions/{id}/output/stream", server.sessionMiddleware( server.handleOutputStream ) )
+  multiplexer.HandleFunc( "GET /sessions/{id}/output/full", server.sessionMiddleware( server.handleOutputFull ) )
+  multiplexer.HandleFunc( "GET /sessions/{id}/state", server.sessionMiddleware( server.handleState ) )
+  multiplexer.HandleFunc( "GET /sessions/{id}/wait", server.sessionMiddleware( server.handleWait ) )
+  multiplexer.HandleFunc( "GET /sessions/{id}/history", server.sessionMiddleware( server.handleHistory ) )
+  multiplexer.HandleFunc( "GET /sessions/{id}/history/{command}", server.sessionMiddleware( server.handleHistoryEntry ) )
+  multiplexer.HandleFunc( "GET /sessions/{id}/jobs/{job}", server.sessionMiddleware( server.handleJob ) )
+  multiplexer.HandleFunc( "DELETE /sessions/{id}/jobs/{job}", server.sessionMiddleware( server.handleDeleteJob ) )
+  multiplexer.HandleFunc( "POST /sessions/{id}/processes", server.sessionMiddleware( server.handleStartProcess ) )
+  multiplexer.HandleFunc( "GET /sessions/{id}/processes", server.sessionMiddleware( server.handleListProcesses ) )
+  multiplexer.HandleFunc( "GET /sessions/{id}/processes/tree", server.sessionMiddleware( server.handleProcessTree ) )
+  multiplexer.HandleFunc( "GET /sessions/{id}/processes/{name}", server.sessionMiddleware( server.handleProcess ) )
+  multiplexer.HandleFunc( "GET /sessions/{id}/processes/{name}/output", server.sessionMiddleware( server.handleProcessOutput ) )
+  multiplexer.HandleFunc( "DELETE /sessions/{id}/processes/{name}", server.sessionMiddleware( server.handleStopProcess ) )
+  multiplexer.HandleFunc( "GET /sessions/{id}/terminal", server.sessionMiddleware( server.handleTerminal ) )
+
   httpServer := &http.Server{
     Addr:        fmt.Sprintf( ":%d", cfg.Server.Port ),
     Handler:     multiplexer,
@@ -105,6 +193,7 @@ func main() {
 
     server.hooks.RunUnlock( shutdownCtx, server.key )
     server.shell.Unlock()
+    server.sessions.CloseAll( shutdownCtx )
     httpServer.Shutdown( shutdownCtx )
   }()
 
@@ -128,7 +217,8 @@ func ( server *serverInstance ) setKeyMiddleware( next http.HandlerFunc ) http.H
   return func( writer http.ResponseWriter, request *http.Request ) {
     providedKey := request.Header.Get( "X-Shell-Key" )
     if providedKey == "" {
-      http.Error( writer, "The X-Shell-Key header is required.", http.StatusUnauthorized )
+      writeError( writer, request, http.StatusUnauthorized, errorCodeUnauthorized,
+                  "The X-Shell-Key header is required.", "" )
       return
     }
 
@@ -142,7 +232,8 @@ func ( server *serverInstance ) setKeyMiddleware( next http.HandlerFunc ) http.H
     server.keyMutex.Unlock()
 
     if providedKey != key {
-      http.Error( writer, "The provided key does not match the locked key.", http.StatusUnauthorized )
+      writeError( writer, request, http.StatusUnauthorized, errorCodeUnauthorized,
+                  "The provided key does not match the locked key.", "" )
       return
     }
 
@@ -155,7 +246,8 @@ func ( server *serverInstance ) verifyKeyMiddleware( next http.HandlerFunc ) htt
   return func( writer http.ResponseWriter, request *http.Request ) {
     providedKey := request.Header.Get( "X-Shell-Key" )
     if providedKey == "" {
-      http.Error( writer, "The X-Shell-Key header is required.", http.StatusUnauthorized )
+      writeError( writer, request, http.StatusUnauthorized, errorCodeUnauthorized,
+                  "The X-Shell-Key header is required.", "" )
       return
     }
 
@@ -164,12 +256,14 @@ func ( server *serverInstance ) verifyKeyMiddleware( next http.HandlerFunc ) htt
     server.keyMutex.RUnlock()
 
     if key == "" {
-      http.Error( writer, "The shell has not been locked.", http.StatusConflict )
+      writeError( writer, request, http.StatusConflict, errorCodeNotLocked,
+                  "The shell has not been locked.", "" )
       return
     }
 
     if providedKey != key {
-      http.Error( writer, "The provided key does not match the locked key.", http.StatusUnauthorized )
+      writeError( writer, request, http.StatusUnauthorized, errorCodeUnauthorized,
+                  "The provided key does not match the locked key.", "" )
       return
     }
 
@@ -184,12 +278,20 @@ func ( server *serverInstance ) handleLock( writer http.ResponseWriter,
 
   if err := server.shell.Start(); err != nil {
     state := server.shell.State()
-    if state == shell.StateLocked || state == shell.StateExecuting {
-      http.Error( writer, "The shell is already locked.", http.StatusConflict )
-    } else if state == shell.StateUnrecoverable {
-      http.Error( writer, "The shell is in an unrecoverable state.", http.StatusConflict )
+    if state == shell.StateLocked || state == shell.StateExecuting || state == shell.StateAttached {
+      writeError( writer, request, http.StatusConflict, errorCodeLocked,
+                  "The shell is already locked.", string( state ) )
+      return
+    }
+
+    // the details of a failed start stay in the log
+    server.logger.Error( "Server | Lock | The shell could not be started.", "error", err )
+    if state == shell.StateUnrecoverable {
+      writeError( writer, request, http.StatusConflict, errorCodeUnrecoverable,
+                  "The shell is in an unrecoverable state.", string( state ) )
     } else {
-      http.Error( writer, "The shell could not be started.", http.StatusInternalServerError )
+      writeError( writer, request, http.StatusInternalServerError, errorCodeInternal,
+                  "The shell could not be started.", string( state ) )
     }
     return
   }
@@ -201,60 +303,308 @@ func ( server *serverInstance ) handleExecute( writer http.ResponseWriter,
                                                request *http.Request ) {
   body, err := io.ReadAll( request.Body )
   if err != nil {
-    http.Error( writer, "The request body could not be read.", http.StatusBadRequest )
+    writeError( writer, request, http.StatusBadRequest, errorCodeInvalidRequest,
+                "The request body could not be read.", "" )
     return
   }
   defer request.Body.Close()
 
-  command := string( body )
+  // a JSON request carries the command and its options in the body, a raw request carries the
+  // command as the body and the options in headers
+  var executeBody executeRequest
+  if isJSONRequest( request ) {
+    if err := json.Unmarshal( body, &executeBody ); err != nil {
+      writeError( writer, request, http.StatusBadRequest, errorCodeInvalidRequest,
+                  "The request body is not valid JSON.", "" )
+      return
+    }
+  } else {
+    executeBody.Command = string( body )
+    executeBody.Timeout = request.Header.Get( "X-Command-Timeout" )
+
+    if header := request.Header.Get( "X-Exact-Output" ); header != "" {
+      exactOutput, err := strconv.ParseBool( header )
+      if err != nil {
+        writeError( writer, request, http.StatusBadRequest, errorCodeInvalidOptions,
+                    "The X-Exact-Output header is invalid.", "" )
+        return
+      }
+      executeBody.ExactOutput = &exactOutput
+    }
+    executeBody.OutputFilter = request.Header.Get( "X-Output-Filter" )
+
+    executeBody.Environment, err = parseEnvironment( request.Header.Values( "X-Command-Env" ) )
+    if err != nil {
+      writeError( writer, request, http.StatusBadRequest, errorCodeInvalidOptions, err.Error(), "" )
+      return
+    }
+    executeBody.WorkingDirectory = request.Header.Get( "X-Command-Cwd" )
+  }
+
+  command := executeBody.Command
   if command == "" {
-    http.Error( writer, "The command cannot be empty.", http.StatusBadRequest )
+    writeError( writer, request, http.StatusBadRequest, errorCodeEmptyCommand,
+                "The command cannot be empty.", "" )
     return
   }
 
-  timeout := server.cfg.Timeout.CommandDuration
-  if timeoutHeader := request.Header.Get( "X-Command-Timeout" ); timeoutHeader != "" {
-    parsedTimeout, err := time.ParseDuration( timeoutHeader )
+  timeout, err := server.commandTimeout( executeBody.Timeout )
+  if err != nil {
+    message := "The X-Command-Timeout header is invalid."
+    if isJSONRequest( request ) {
+      message = "The timeout value is invalid."
+    }
+    writeError( writer, request, http.StatusBadRequest, errorCodeInvalidTimeout, message, "" )
+    return
+  }
+
+  outputFilter := server.cfg.Shell.OutputFilter
+  if executeBody.OutputFilter != "" {
+    outputFilter = executeBody.OutputFilter
+  }
+  filter, err := shell.ParseFilter( outputFilter )
+  if err != nil {
+    writeError( writer, request, http.StatusBadRequest, errorCodeInvalidOptions,
+                "The output filter must be none, strip or render.", "" )
+    return
+  }
+
+  options := shell.Options{
+    Environment:      executeBody.Environment,
+    WorkingDirectory: executeBody.WorkingDirectory,
+    SeparateStderr:   executeBody.SeparateStderr,
+    ExactOutput:      server.cfg.Shell.ExactOutput,
+    Filter:           filter,
+  }
+  if executeBody.ExactOutput != nil {
+    options.ExactOutput = *executeBody.ExactOutput
+  }
+
+  // an asynchronous command is not waited for; the caller follows it through its job
+  async := false
+  if value := request.URL.Query().Get( "async" ); value != "" {
+    async, err = strconv.ParseBool( value )
     if err != nil {
-      http.Error( writer, "The X-Command-Timeout header is invalid.", http.StatusBadRequest )
+      writeError( writer, request, http.StatusBadRequest, errorCodeInvalidOptions,
+                  "The async parameter is invalid.", "" )
       return
     }
-    if parsedTimeout > server.cfg.Timeout.CommandMaximumDuration {
-      parsedTimeout = server.cfg.Timeout.CommandMaximumDuration
+  }
+
+  if wantsEventStream( request ) {
+    server.streamExecute( writer, request, command, timeout, options )
+    return
+  }
+  if async {
+    server.submitExecute( writer, request, command, options )
+    return
+  }
+
+  target := server.requestShell( request )
+  result, err := target.Execute( command, timeout, options )
+  if err == shell.ErrTimeout {
+    server.writeTimeout( writer, request, result.ID )
+    return
+  }
+  if err != nil {
+    if result.ID != 0 {
+      writer.Header().Set( "X-Job-ID", strconv.FormatUint( result.ID, 10 ) )
     }
-    if parsedTimeout > 0 {
-      timeout = parsedTimeout
+    server.writeExecuteError( writer, request, err )
+    return
+  }
+  server.writeResult( writer, request, result )
+}
+
+// parseEnvironment parses per-command variables given as NAME=value; the value may contain further
+// equals signs
+func parseEnvironment( values []string ) ( map[string]string, error ) {
+  if len( values ) == 0 {
+    return nil, nil
+  }
+  environment := make( map[string]string, len( values ) )
+  for _, value := range values {
+    name, variable, ok := strings.Cut( value, "=" )
+    if !ok || name == "" {
+      return nil, fmt.Errorf( "The X-Command-Env header must be of the form NAME=value." )
     }
+    environment[name] = variable
   }
+  return environment, nil
+}
 
-  output, err := server.shell.Execute( command, timeout )
+// commandTimeout parses a requested timeout; an empty or zero timeout is the configured default and
+// a timeout above the configured maximum is capped
+func ( server *serverInstance ) commandTimeout( value string ) ( time.Duration, error ) {
+  timeout := server.cfg.Timeout.CommandDuration
+  if value == "" {
+    return timeout, nil
+  }
+  parsedTimeout, err := time.ParseDuration( value )
   if err != nil {
-    if err == shell.ErrTimeout {
-      http.Error( writer, "The command timed out. The shell is busy and the command is still running.",
-                  http.StatusAccepted )
-      return
+    return 0, err
+  }
+  if parsedTimeout > server.cfg.Timeout.CommandMaximumDuration {
+    parsedTimeout = server.cfg.Timeout.CommandMaximumDuration
+  }
+  if parsedTimeout > 0 {
+    timeout = parsedTimeout
+  }
+  return timeout, nil
+}
+
+// writeResult writes the response for a completed command; stderr is only included in JSON when
+// it was separated
+func ( server *serverInstance ) writeResult( writer http.ResponseWriter,
+                                             request *http.Request,
+                                             result shell.Result ) {
+  writer.Header().Set( "X-Job-ID", strconv.FormatUint( result.ID, 10 ) )
+  writer.Header().Set( "X-Exit-Code", strconv.Itoa( result.ExitCode ) )
+  writer.Header().Set( "X-Output-Truncated", strconv.FormatBool( result.Truncated ) )
+  if wantsJSON( request ) {
+    response := executeResponse{
+      JobID:      result.ID,
+      Output:     result.Output,
+      ExitCode:   &result.ExitCode,
+      DurationMs: result.Duration.Milliseconds(),
+      State:      string( server.requestShell( request ).State() ),
+      Truncated:  result.Truncated,
     }
-    state := server.shell.State()
-    if state == shell.StateAvailable {
-      http.Error( writer, "The shell has not been locked.", http.StatusConflict )
-    } else if state == shell.StateExecuting {
-      http.Error( writer, "The shell is busy executing another command.", http.StatusConflict )
-    } else if state == shell.StateUnrecoverable {
-      http.Error( writer, "The shell is in an unrecoverable state.", http.StatusConflict )
-    } else {
-      http.Error( writer, "The command could not be executed.", http.StatusInternalServerError )
+    if result.SeparateStderr {
+      response.Stderr = &result.Stderr
     }
+    writeJSON( writer, http.StatusOK, response )
     return
   }
 
   writer.WriteHeader( http.StatusOK )
-  writer.Write( []byte( output ) )
+  writer.Write( []byte( result.Output ) )
+}
+
+// writeTimeout writes the 202 response for a command that is still running when the caller stops
+// waiting for it
+func ( server *serverInstance ) writeTimeout( writer http.ResponseWriter,
+                                              request *http.Request,
+                                              id uint64 ) {
+  target := server.requestShell( request )
+  message := "The command timed out. The shell is busy and the command is still running."
+  awaitingInput := target.AwaitingInput()
+  writer.Header().Set( "X-Job-ID", strconv.FormatUint( id, 10 ) )
+  writer.Header().Set( "X-Awaiting-Input", strconv.FormatBool( awaitingInput ) )
+  if wantsJSON( request ) {
+    writeJSON( writer, http.StatusAccepted, executeResponse{
+      JobID:         id,
+      State:         string( target.State() ),
+      AwaitingInput: awaitingInput,
+      Error:         errorCodeTimeout,
+      Message:       message,
+    } )
+    return
+  }
+  http.Error( writer, message, http.StatusAccepted )
+}
+
+// writeExecuteError writes the response for a command that could not be executed
+func ( server *serverInstance ) writeExecuteError( writer http.ResponseWriter,
+                                                   request *http.Request,
+                                                   err error ) {
+  state := server.requestShell( request ).State()
+  if errors.Is( err, shell.ErrInvalidOptions ) {
+    writeError( writer, request, http.StatusBadRequest, errorCodeInvalidOptions,
+                err.Error(), string( state ) )
+  } else if errors.Is( err, shell.ErrCancelled ) {
+    writeError( writer, request, http.StatusConflict, errorCodeCancelled,
+                err.Error(), string( state ) )
+  } else if errors.Is( err, shell.ErrRestarted ) {
+    writeError( writer, request, http.StatusConflict, errorCodeRestarted,
+                err.Error(), string( state ) )
+  } else if state == shell.StateAvailable {
+    writeError( writer, request, http.StatusConflict, errorCodeNotLocked,
+                "The shell has not been locked.", string( state ) )
+  } else if state == shell.StateExecuting {
+    writeError( writer, request, http.StatusConflict, errorCodeBusy,
+                "The shell is busy executing another command.", string( state ) )
+  } else if state == shell.StateAttached {
+    writeError( writer, request, http.StatusConflict, errorCodeAttached,
+                "A terminal is attached to the shell.", string( state ) )
+  } else if state == shell.StateUnrecoverable {
+    writeError( writer, request, http.StatusConflict, errorCodeUnrecoverable,
+                "The shell is in an unrecoverable state.", string( state ) )
+  } else {
+    writeError( writer, request, http.StatusInternalServerError, errorCodeInternal,
+                "The command could not be executed.", string( state ) )
+  }
 }
 
 func ( server *serverInstance ) handleKill( writer http.ResponseWriter,
                                             request *http.Request ) {
-  if err := server.shell.Kill(); err != nil {
-    http.Error( writer, "The shell could not be killed.", http.StatusInternalServerError )
+  if value := request.URL.Query().Get( "escalate" ); value != "" {
+    escalate, err := strconv.ParseBool( value )
+    if err != nil {
+      writeError( writer, request, http.StatusBadRequest, errorCodeInvalidOptions,
+                  "The escalate parameter must be true or false.", "" )
+      return
+    }
+    if escalate {
+      server.handleKillEscalating( writer, request )
+      return
+    }
+  }
+
+  target := server.requestShell( request )
+  if err := target.Kill(); err != nil {
+    writeError( writer, request, http.StatusInternalServerError, errorCodeKillFailed,
+                "The shell could not be killed.", string( target.State() ) )
+    return
+  }
+
+  writer.WriteHeader( http.StatusOK )
+}
+
+func ( server *serverInstance ) handleInput( writer http.ResponseWriter,
+                                             request *http.Request ) {
+  body, err := io.ReadAll( request.Body )
+  if err != nil {
+    writeError( writer, request, http.StatusBadRequest, errorCodeInvalidRequest,
+                "The request body could not be read.", "" )
+    return
+  }
+  defer request.Body.Close()
+
+  // a JSON request carries the input and flags in the body, a raw request carries the input as the
+  // body and the flags as query parameters
+  var input inputRequest
+  if isJSONRequest( request ) {
+    if err := json.Unmarshal( body, &input ); err != nil {
+      writeError( writer, request, http.StatusBadRequest, errorCodeInvalidRequest,
+                  "The request body is not valid JSON.", "" )
+      return
+    }
+  } else {
+    input.Data = string( body )
+    for name, flag := range map[string]*bool{ "newline": &input.Newline, "eof": &input.EOF } {
+      value := request.URL.Query().Get( name )
+      if value == "" {
+        continue
+      }
+      if *flag, err = strconv.ParseBool( value ); err != nil {
+        writeError( writer, request, http.StatusBadRequest, errorCodeInvalidOptions,
+                    fmt.Sprintf( "The %s parameter is invalid.", name ), "" )
+        return
+      }
+    }
+  }
+
+  target := server.requestShell( request )
+  if err := target.Input( []byte( input.Data ), input.Newline, input.EOF ); err != nil {
+    state := target.State()
+    if errors.Is( err, shell.ErrNotExecuting ) {
+      writeError( writer, request, http.StatusConflict, errorCodeNotExecuting,
+                  "There is no running command to receive input.", string( state ) )
+    } else {
+      writeError( writer, request, http.StatusInternalServerError, errorCodeInternal,
+                  "The input could not be sent.", string( state ) )
+    }
     return
   }
 
@@ -273,13 +623,14 @@ func ( server *serverInstance ) handleUnlock( writer http.ResponseWriter,
       syscall.Kill( syscall.Getpid(), syscall.SIGTERM )
     }()
   } else {
-    // recycle mode: terminate shell, clear key, stay running for next client
+    // recycle mode: terminate shell and sessions, clear key, stay running for next client
     server.hooks.RunUnlock( request.Context(), server.key )
     server.shell.Unlock()
 
     server.keyMutex.Lock()
     server.key = ""
     server.keyMutex.Unlock()
+    server.sessions.CloseAll( request.Context() )
 
     server.logger.Info( "Server | Unlock | The shell has been recycled and is available for a new client." )
     writer.WriteHeader( http.StatusOK )
@@ -288,14 +639,78 @@ func ( server *serverInstance ) handleUnlock( writer http.ResponseWriter,
 
 func ( server *serverInstance ) handleOutput( writer http.ResponseWriter,
                                               request *http.Request ) {
+  // a part of the output is served from the complete output
+  if request.Header.Get( "Range" ) != "" {
+    server.handleOutputFull( writer, request )
+    return
+  }
+  if isOutputPageRequest( request ) {
+    server.handleOutputPage( writer, request )
+    return
+  }
+
+  result := server.requestShell( request ).Output()
+  if result == nil {
+    writer.WriteHeader( http.StatusOK )
+    return
+  }
+
+  writer.Header().Set( "X-Job-ID", strconv.FormatUint( result.ID, 10 ) )
+  writer.Header().Set( "X-Exit-Code", strconv.Itoa( result.ExitCode ) )
+  writer.Header().Set( "X-Output-Truncated", strconv.FormatBool( result.Truncated ) )
   writer.WriteHeader( http.StatusOK )
-  writer.Write( []byte( server.shell.Output() ) )
+  writer.Write( []byte( result.Output ) )
+}
+
+func ( server *serverInstance ) handleWait( writer http.ResponseWriter,
+                                            request *http.Request ) {
+  timeout, err := server.commandTimeout( request.URL.Query().Get( "timeout" ) )
+  if err != nil {
+    writeError( writer, request, http.StatusBadRequest, errorCodeInvalidTimeout,
+                "The timeout parameter is invalid.", "" )
+    return
+  }
+
+  ctx, cancel := context.WithTimeout( request.Context(), timeout )
+  defer cancel()
+
+  target := server.requestShell( request )
+  result, err := target.Wait( ctx )
+  if errors.Is( err, context.DeadlineExceeded ) {
+    server.writeTimeout( writer, request, result.ID )
+    return
+  }
+  if errors.Is( err, context.Canceled ) {
+    return
+  }
+  if errors.Is( err, shell.ErrNoCommand ) {
+    writeError( writer, request, http.StatusNotFound, errorCodeNoCommand, err.Error(), string( target.State() ) )
+    return
+  }
+  if errors.Is( err, shell.ErrRestarted ) {
+    writeError( writer, request, http.StatusConflict, errorCodeRestarted, err.Error(), string( target.State() ) )
+    return
+  }
+  if err != nil {
+    writeError( writer, request, http.StatusInternalServerError, errorCodeInternal, err.Error(), string( target.State() ) )
+    return
+  }
+  server.writeResult( writer, request, result )
 }
 
 func ( server *serverInstance ) handleState( writer http.ResponseWriter,
                                              request *http.Request ) {
+  target := server.requestShell( request )
+  status := target.Status()
+  awaitingInput := target.AwaitingInput()
+
+  writer.Header().Set( "X-Awaiting-Input", strconv.FormatBool( awaitingInput ) )
+  if wantsJSON( request ) {
+    writeJSON( writer, http.StatusOK, newStateResponse( status, awaitingInput ) )
+    return
+  }
   writer.WriteHeader( http.StatusOK )
-  writer.Write( []byte( server.shell.State() ) )
+  writer.Write( []byte( status.State ) )
 }
 
 func ( server *serverInstance ) handleHealth( writer http.ResponseWriter,
@@ -0,0 +1,232 @@
+package main
+
+import (
+  "bufio"
+  "fmt"
+  "io"
+  "net/http"
+  "strconv"
+  "strings"
+  "time"
+
+  "github.com/endless/shelld/internal/shell"
+)
+
+// outputPageResponse is the body of a JSON /output response for a part of the output
+type outputPageResponse struct {
+  JobID    uint64 `json:"job_id"`
+  Output   string `json:"output"`
+  Offset   int64  `json:"offset"`
+  Cursor   int64  `json:"cursor"`
+  Size     int64  `json:"size"`
+  Complete bool   `json:"complete"`
+  State    string `json:"state"`
+}
+
+// isOutputPageRequest reports whether the request asks for a part of the output
+func isOutputPageRequest( request *http.Request ) bool {
+  query := request.URL.Query()
+  return query.Has( "offset" ) || query.Has( "length" ) || query.Has( "lines" ) || query.Has( "since" ) ||
+         query.Has( "job" )
+}
+
+// handleOutputFull serves the complete output of the running or last command, before filtering and
+// truncation; Range requests fetch a part of it
+func ( server *serverInstance ) handleOutputFull( writer http.ResponseWriter,
+                                                  request *http.Request ) {
+  target := server.requestShell( request )
+  reader, err := target.OpenOutput()
+  if err != nil {
+    writeError( writer, request, http.StatusNotFound, errorCodeNoCommand, err.Error(), string( target.State() ) )
+    return
+  }
+  defer reader.Close()
+
+  writer.Header().Set( "Content-Type", "text/plain; charset=utf-8" )
+  http.ServeContent( writer, request, "", time.Time{}, reader )
+}
+
+// handleOutputPage serves a part of the complete output of the running or last command selected by
+// a byte range, a line range or a cursor returned by an earlier request; a part is never longer
+// than the returned bytes limit and the cursor tells where the next part starts. The part carries
+// the job it belongs to, and a job passed back is rejected once another command has started
+func ( server *serverInstance ) handleOutputPage( writer http.ResponseWriter,
+                                                  request *http.Request ) {
+  target := server.requestShell( request )
+  query := request.URL.Query()
+
+  var job uint64
+  if value := query.Get( "job" ); value != "" {
+    var err error
+    if job, err = strconv.ParseUint( value, 10, 64 ); err != nil || job == 0 {
+      writeError( writer, request, http.StatusBadRequest, errorCodeInvalidRange,
+                  "The job parameter must be a job ID.", "" )
+      return
+    }
+  }
+
+  // the state is read before the output so a command that completes in between is reported as still
+  // running and the caller polls once more
+  state := target.State()
+  reader, job, err := target.OpenCommandOutput( job )
+  if err == shell.ErrNotCurrentCommand {
+    writeError( writer, request, http.StatusConflict, errorCodeNotCurrent, err.Error(), string( state ) )
+    return
+  }
+  if err != nil {
+    writeError( writer, request, http.StatusNotFound, errorCodeNoCommand, err.Error(), string( state ) )
+    return
+  }
+  defer reader.Close()
+
+  size, err := reader.Seek( 0, io.SeekEnd )
+  if err != nil {
+    writeError( writer, request, http.StatusInternalServerError, errorCodeInternal, err.Error(), string( state ) )
+    return
+  }
+
+  limit := server.cfg.Shell.MaximumReturned
+
+  var offset int64
+  var page []byte
+  if query.Has( "lines" ) {
+    first, last, err := parseLineRange( query.Get( "lines" ) )
+    if err != nil {
+      writeError( writer, request, http.StatusBadRequest, errorCodeInvalidRange, err.Error(), "" )
+      return
+    }
+    offset, page, err = readLines( reader, first, last, limit )
+    if err != nil {
+      writeError( writer, request, http.StatusInternalServerError, errorCodeInternal, err.Error(), string( state ) )
+      return
+    }
+  } else {
+    name := "offset"
+    if query.Has( "since" ) {
+      name = "since"
+    }
+    offset, err = parseCount( query, name, 0 )
+    if err != nil {
+      writeError( writer, request, http.StatusBadRequest, errorCodeInvalidRange, err.Error(), "" )
+      return
+    }
+    length, err := parseCount( query, "length", int64( limit ) )
+    if err != nil || length == 0 {
+      writeError( writer, request, http.StatusBadRequest, errorCodeInvalidRange,
+                  "The length parameter must be a positive number.", "" )
+      return
+    }
+    page, err = readRange( reader, offset, min( length, int64( limit ) ) )
+    if err != nil {
+      writeError( writer, request, http.StatusInternalServerError, errorCodeInternal, err.Error(), string( state ) )
+      return
+    }
+  }
+
+  response := outputPageResponse{
+    JobID:    job,
+    Output:   string( page ),
+    Offset:   offset,
+    Cursor:   offset + int64( len( page ) ),
+    Size:     size,
+    Complete: state != shell.StateExecuting,
+    State:    string( state ),
+  }
+
+  writer.Header().Set( "X-Job-ID", strconv.FormatUint( response.JobID, 10 ) )
+  writer.Header().Set( "X-Output-Offset", strconv.FormatInt( response.Offset, 10 ) )
+  writer.Header().Set( "X-Output-Cursor", strconv.FormatInt( response.Cursor, 10 ) )
+  writer.Header().Set( "X-Output-Size", strconv.FormatInt( response.Size, 10 ) )
+  writer.Header().Set( "X-Output-Complete", strconv.FormatBool( response.Complete ) )
+  if wantsJSON( request ) {
+    writeJSON( writer, http.StatusOK, response )
+    return
+  }
+  writer.Header().Set( "Content-Type", "text/plain; charset=utf-8" )
+  writer.WriteHeader( http.StatusOK )
+  writer.Write( page )
+}
+
+// parseCount parses a non-negative number query parameter, returning fallback if it is absent
+func parseCount( query map[string][]string, name string, fallback int64 ) ( int64, error ) {
+  values, ok := query[name]
+  if !ok || len( values ) == 0 {
+    return fallback, nil
+  }
+  count, err := strconv.ParseInt( values[0], 10, 64 )
+  if err != nil || count < 0 {
+    return 0, fmt.Errorf( "The %s parameter must be a non-negative number.", name )
+  }
+  return count, nil
+}
+
+// parseLineRange parses a line range of the form first-last, first- or first; lines are numbered
+// from 1 and a last line of 0 is the end of the output
+func parseLineRange( value string ) ( int, int, error ) {
+  invalid := fmt.Errorf( "The lines parameter must be a line range such as 10-20, 10- or 10." )
+
+  firstValue, lastValue, isRange := strings.Cut( value, "-" )
+  first, err := strconv.Atoi( firstValue )
+  if err != nil || first < 1 {
+    return 0, 0, invalid
+  }
+  if !isRange {
+    return first, first, nil
+  }
+  if lastValue == "" {
+    return first, 0, nil
+  }
+  last, err := strconv.Atoi( lastValue )
+  if err != nil || last < first {
+    return 0, 0, invalid
+  }
+  return first, last, nil
+}
+
+// readRange reads up to length bytes starting at the offset
+func readRange( reader io.ReadSeeker, offset int64, length int64 ) ( []byte, error ) {
+  if _, err := reader.Seek( offset, io.SeekStart ); err != nil {
+    return nil, err
+  }
+  return io.ReadAll( io.LimitReader( reader, length ) )
+}
+
+// readLines reads the lines from first to last, stopping at limit bytes, and returns the offset of
+// the first line
+func readLines( reader io.ReadSeeker, first int, last int, limit int ) ( int64, []byte, error ) {
+  if _, err := reader.Seek( 0, io.SeekStart ); err != nil {
+    return 0, nil, err
+  }
+
+  buffered := bufio.NewReader( reader )
+  var offset, position int64
+  var page []byte
+  line := 1
+  for {
+    chunk, err := buffered.ReadSlice( '\n' )
+    if line < first {
+      offset = position + int64( len( chunk ) )
+    } else if len( page )+len( chunk ) >= limit {
+      page = append( page, chunk[:limit-len( page )]... )
+      return offset, page, nil
+    } else {
+      page = append( page, chunk... )
+    }
+    position += int64( len( chunk ) )
+
+    // a line longer than the read buffer arrives in several chunks
+    if err == bufio.ErrBufferFull {
+      continue
+    }
+    if err == io.EOF {
+      return offset, page, nil
+    }
+    if err != nil {
+      return 0, nil, err
+    }
+    if line == last {
+      return offset, page, nil
+    }
+    line++
+  }
+}
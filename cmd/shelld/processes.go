@@ -0,0 +1,252 @@
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+  "errors"
+  "io"
+  "net/http"
+  "strconv"
+  "time"
+
+  "github.com/endless/shelld/internal/shell"
+)
+
+// processRequest is the body of a JSON request to start a background process
+type processRequest struct {
+  Name             string            `json:"name"`
+  Command          string            `json:"command"`
+  Environment      map[string]string `json:"env"`
+  WorkingDirectory string            `json:"cwd"`
+}
+
+// processResponse describes a background process; the exit code is only set once it has exited
+type processResponse struct {
+  Name     string     `json:"name"`
+  Command  string     `json:"command"`
+  PID      int        `json:"pid"`
+  Status   string     `json:"status"`
+  Started  time.Time  `json:"started"`
+  Ended    *time.Time `json:"ended,omitempty"`
+  UptimeMs int64      `json:"uptime_ms"`
+  ExitCode *int       `json:"exit_code"`
+}
+
+// processTreeResponse lists the processes started by the shell with the PID of the shell itself
+type processTreeResponse struct {
+  PID       int                `json:"pid"`
+  Processes []processTreeEntry `json:"processes"`
+}
+
+// processTreeEntry describes a descendant of the shell process
+type processTreeEntry struct {
+  PID          int    `json:"pid"`
+  ParentID     int    `json:"ppid"`
+  Command      string `json:"command"`
+  State        string `json:"state"`
+  CPUTimeMs    int64  `json:"cpu_ms"`
+  ResidentSize int64  `json:"rss_bytes"`
+}
+
+func ( server *serverInstance ) handleStartProcess( writer http.ResponseWriter,
+                                                    request *http.Request ) {
+  body, err := io.ReadAll( request.Body )
+  if err != nil {
+    writeError( writer, request, http.StatusBadRequest, errorCodeInvalidRequest,
+                "The request body could not be read.", "" )
+    return
+  }
+  defer request.Body.Close()
+
+  // a JSON request carries the process in the body, a raw request carries the command as the body,
+  // the name as a query parameter and the options in headers
+  var processBody processRequest
+  if isJSONRequest( request ) {
+    if err := json.Unmarshal( body, &processBody ); err != nil {
+      writeError( writer, request, http.StatusBadRequest, errorCodeInvalidRequest,
+                  "The request body is not valid JSON.", "" )
+      return
+    }
+  } else {
+    processBody.Command = string( body )
+    processBody.Name = request.URL.Query().Get( "name" )
+    processBody.Environment, err = parseEnvironment( request.Header.Values( "X-Command-Env" ) )
+    if err != nil {
+      writeError( writer, request, http.StatusBadRequest, errorCodeInvalidOptions, err.Error(), "" )
+      return
+    }
+    processBody.WorkingDirectory = request.Header.Get( "X-Command-Cwd" )
+  }
+
+  if processBody.Command == "" {
+    writeError( writer, request, http.StatusBadRequest, errorCodeEmptyCommand,
+                "The command cannot be empty.", "" )
+    return
+  }
+
+  // GET /processes/tree would hide a process with this name
+  if processBody.Name == "tree" {
+    writeError( writer, request, http.StatusBadRequest, errorCodeInvalidOptions,
+                "The process name 'tree' is reserved.", "" )
+    return
+  }
+
+  target := server.requestShell( request )
+  process, err := target.StartProcess( processBody.Name, processBody.Command, shell.Options{
+    Environment:      processBody.Environment,
+    WorkingDirectory: processBody.WorkingDirectory,
+  } )
+  if errors.Is( err, shell.ErrShellNotRunning ) {
+    state := target.State()
+    writeError( writer, request, http.StatusConflict, errorCodeForState( state ), err.Error(), string( state ) )
+    return
+  }
+  if errors.Is( err, shell.ErrInvalidProcessName ) || errors.Is( err, shell.ErrInvalidOptions ) {
+    writeError( writer, request, http.StatusBadRequest, errorCodeInvalidOptions, err.Error(), "" )
+    return
+  }
+  if errors.Is( err, shell.ErrProcessExists ) {
+    writeError( writer, request, http.StatusConflict, errorCodeProcessExists, err.Error(), "" )
+    return
+  }
+  if err != nil {
+    writeError( writer, request, http.StatusInternalServerError, errorCodeInternal, err.Error(), "" )
+    return
+  }
+  writeJSON( writer, http.StatusCreated, newProcessResponse( process ) )
+}
+
+func ( server *serverInstance ) handleListProcesses( writer http.ResponseWriter,
+                                                     request *http.Request ) {
+  processes := server.requestShell( request ).Processes()
+
+  response := make( []processResponse, 0, len( processes ) )
+  for _, process := range processes {
+    response = append( response, newProcessResponse( process ) )
+  }
+  writeJSON( writer, http.StatusOK, response )
+}
+
+func ( server *serverInstance ) handleProcess( writer http.ResponseWriter,
+                                               request *http.Request ) {
+  process, err := server.requestShell( request ).Process( request.PathValue( "name" ) )
+  if err != nil {
+    writeError( writer, request, http.StatusNotFound, errorCodeProcessNotFound, err.Error(), "" )
+    return
+  }
+  writeJSON( writer, http.StatusOK, newProcessResponse( process ) )
+}
+
+// handleProcessOutput serves the end of the output of a background process; lines=N limits it to
+// the last N lines and it is never longer than the returned bytes limit
+func ( server *serverInstance ) handleProcessOutput( writer http.ResponseWriter,
+                                                     request *http.Request ) {
+  target := server.requestShell( request )
+  name := request.PathValue( "name" )
+
+  lines, err := parseCount( request.URL.Query(), "lines", 0 )
+  if err != nil {
+    writeError( writer, request, http.StatusBadRequest, errorCodeInvalidRange, err.Error(), "" )
+    return
+  }
+  process, err := target.Process( name )
+  if err != nil {
+    writeError( writer, request, http.StatusNotFound, errorCodeProcessNotFound, err.Error(), "" )
+    return
+  }
+  output, err := target.ProcessOutput( name )
+  if err != nil {
+    writeError( writer, request, http.StatusNotFound, errorCodeProcessNotFound, err.Error(), "" )
+    return
+  }
+
+  if lines > 0 {
+    output = lastLines( output, int( lines ) )
+  }
+  if limit := server.cfg.Shell.MaximumReturned; limit > 0 && len( output ) > limit {
+    output = output[len( output )-limit:]
+  }
+
+  writer.Header().Set( "X-Process-Status", string( process.Status ) )
+  if process.Status == shell.ProcessExited {
+    writer.Header().Set( "X-Exit-Code", strconv.Itoa( process.ExitCode ) )
+  }
+  writer.Header().Set( "Content-Type", "text/plain; charset=utf-8" )
+  writer.WriteHeader( http.StatusOK )
+  writer.Write( output )
+}
+
+func ( server *serverInstance ) handleStopProcess( writer http.ResponseWriter,
+                                                   request *http.Request ) {
+  process, err := server.requestShell( request ).StopProcess( request.PathValue( "name" ) )
+  if err != nil {
+    writeError( writer, request, http.StatusNotFound, errorCodeProcessNotFound, err.Error(), "" )
+    return
+  }
+  writeJSON( writer, http.StatusOK, newProcessResponse( process ) )
+}
+
+// handleProcessTree lists every descendant of the shell process so a stuck command can be inspected
+// before it is killed
+func ( server *serverInstance ) handleProcessTree( writer http.ResponseWriter,
+                                                   request *http.Request ) {
+  target := server.requestShell( request )
+  pid, tree, err := target.ProcessTree()
+  if errors.Is( err, shell.ErrShellNotRunning ) {
+    state := target.State()
+    writeError( writer, request, http.StatusConflict, errorCodeForState( state ), err.Error(), string( state ) )
+    return
+  }
+  if err != nil {
+    writeError( writer, request, http.StatusInternalServerError, errorCodeInternal, err.Error(), "" )
+    return
+  }
+
+  response := processTreeResponse{ PID: pid, Processes: make( []processTreeEntry, 0, len( tree ) ) }
+  for _, process := range tree {
+    response.Processes = append( response.Processes, processTreeEntry{
+      PID:          process.PID,
+      ParentID:     process.ParentID,
+      Command:      process.Command,
+      State:        process.State,
+      CPUTimeMs:    process.CPUTime.Milliseconds(),
+      ResidentSize: process.ResidentSize,
+    } )
+  }
+  writeJSON( writer, http.StatusOK, response )
+}
+
+// newProcessResponse describes the background process
+func newProcessResponse( process shell.Process ) processResponse {
+  response := processResponse{
+    Name:     process.Name,
+    Command:  process.Command,
+    PID:      process.PID,
+    Status:   string( process.Status ),
+    Started:  process.Started,
+    UptimeMs: time.Since( process.Started ).Milliseconds(),
+  }
+  if process.Status == shell.ProcessExited {
+    response.Ended = &process.Ended
+    response.UptimeMs = process.Ended.Sub( process.Started ).Milliseconds()
+    response.ExitCode = &process.ExitCode
+  }
+  return response
+}
+
+// lastLines returns the last count lines of the output; a final line without a line break counts
+// as a line
+func lastLines( output []byte, count int ) []byte {
+  end := len( output )
+  if end > 0 && output[end-1] == '\n' {
+    end--
+  }
+  start := end
+  for ; count > 0; count-- {
+    start = bytes.LastIndexByte( output[:start], '\n' )
+    if start < 0 {
+      return output
+    }
+  }
+  return output[start+1:]
+}
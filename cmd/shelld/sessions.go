@@ -0,0 +1,110 @@
+package main
+
+import (
+  "context"
+  "net/http"
+  "time"
+
+  "github.com/endless/shelld/internal/session"
+  "github.com/endless/shelld/internal/shell"
+)
+
+// contextKey is the type of the request context keys set by the middlewares
+type contextKey string
+
+// shellContextKey holds the session shell a request is addressed to
+const shellContextKey contextKey = "shell"
+
+// sessionResponse describes a session
+type sessionResponse struct {
+  ID      string    `json:"id"`
+  State   string    `json:"state"`
+  Created time.Time `json:"created"`
+}
+
+// requestShell returns the shell the request is addressed to: the session shell resolved by the
+// session middleware or the shell locked through /lock
+func ( server *serverInstance ) requestShell( request *http.Request ) *shell.Shell {
+  if target, ok := request.Context().Value( shellContextKey ).( *shell.Shell ); ok {
+    return target
+  }
+  return server.shell
+}
+
+// sessionMiddleware resolves the session a request is addressed to; sessions belong to the key the
+// server is locked to, so the locked key is verified first
+func ( server *serverInstance ) sessionMiddleware( next http.HandlerFunc ) http.HandlerFunc {
+  return server.verifyKeyMiddleware( func( writer http.ResponseWriter, request *http.Request ) {
+    target, err := server.sessions.Get( request.PathValue( "id" ) )
+    if err != nil {
+      writeError( writer, request, http.StatusNotFound, errorCodeSessionNotFound, err.Error(), "" )
+      return
+    }
+
+    if request.Header.Get( "X-Shell-Key" ) != target.Key {
+      writeError( writer, request, http.StatusUnauthorized, errorCodeUnauthorized,
+                  "The provided key does not match the session key.", "" )
+      return
+    }
+
+    next( writer, request.WithContext( context.WithValue( request.Context(), shellContextKey, target.Shell ) ) )
+  } )
+}
+
+func ( server *serverInstance ) handleCreateSession( writer http.ResponseWriter,
+                                                     request *http.Request ) {
+  key := request.Header.Get( "X-Shell-Key" )
+  created, err := server.sessions.Create( request.Context(), key )
+  if err == session.ErrLimitReached {
+    writeError( writer, request, http.StatusConflict, errorCodeSessionLimit, err.Error(), "" )
+    return
+  }
+  if err != nil {
+    server.logger.Error( "Server | CreateSession | The session could not be created.", "error", err )
+    writeError( writer, request, http.StatusInternalServerError, errorCodeInternal,
+                "The shell could not be started.", "" )
+    return
+  }
+
+  // a recycle that cleared the key while the shell started has already closed the other sessions
+  server.keyMutex.RLock()
+  lockedKey := server.key
+  server.keyMutex.RUnlock()
+  if lockedKey != key {
+    server.sessions.Delete( request.Context(), created.ID )
+    writeError( writer, request, http.StatusConflict, errorCodeNotLocked, "The shell has not been locked.", "" )
+    return
+  }
+
+  writeJSON( writer, http.StatusCreated, newSessionResponse( created ) )
+}
+
+func ( server *serverInstance ) handleListSessions( writer http.ResponseWriter,
+                                                    request *http.Request ) {
+  sessions := server.sessions.List( request.Header.Get( "X-Shell-Key" ) )
+
+  response := make( []sessionResponse, 0, len( sessions ) )
+  for _, listed := range sessions {
+    response = append( response, newSessionResponse( listed ) )
+  }
+  writeJSON( writer, http.StatusOK, response )
+}
+
+func ( server *serverInstance ) handleDeleteSession( writer http.ResponseWriter,
+                                                     request *http.Request ) {
+  if err := server.sessions.Delete( request.Context(), request.PathValue( "id" ) ); err == session.ErrNotFound {
+    writeError( writer, request, http.StatusNotFound, errorCodeSessionNotFound, err.Error(), "" )
+    return
+  }
+
+  writer.WriteHeader( http.StatusOK )
+}
+
+// newSessionResponse describes the session
+func newSessionResponse( described *session.Session ) sessionResponse {
+  return sessionResponse{
+    ID:      described.ID,
+    State:   string( described.Shell.State() ),
+    Created: described.Created,
+  }
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+  "context"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "net/http"
+  "strings"
+  "time"
+
+  "github.com/endless/shelld/internal/shell"
+)
+
+// outputEvent is the payload of an output event
+type outputEvent struct {
+  Output string `json:"output"`
+}
+
+// exitEvent is the payload of the final event of a completed command
+type exitEvent struct {
+  JobID      uint64  `json:"job_id"`
+  ExitCode   int     `json:"exit_code"`
+  Stderr     *string `json:"stderr,omitempty"`
+  DurationMs int64   `json:"duration_ms"`
+  State      string  `json:"state"`
+}
+
+// eventStream writes Server-Sent Events to a response
+type eventStream struct {
+  writer     http.ResponseWriter
+  controller *http.ResponseController
+  started    bool
+}
+
+// newEventStream creates an event stream for the response
+func newEventStream( writer http.ResponseWriter ) *eventStream {
+  return &eventStream{
+    writer:     writer,
+    controller: http.NewResponseController( writer ),
+  }
+}
+
+// start writes the response headers so the client sees the stream open before the first event
+func ( stream *eventStream ) start() {
+  if stream.started {
+    return
+  }
+  stream.started = true
+
+  stream.writer.Header().Set( "Content-Type", "text/event-stream" )
+  stream.writer.Header().Set( "Cache-Control", "no-cache" )
+  stream.writer.WriteHeader( http.StatusOK )
+  stream.controller.Flush()
+}
+
+// send writes an event with a JSON payload and flushes it to the client
+func ( stream *eventStream ) send( event string, value any ) {
+  stream.start()
+
+  data, _ := json.Marshal( value )
+  fmt.Fprintf( stream.writer, "event: %s\ndata: %s\n\n", event, data )
+  stream.controller.Flush()
+}
+
+// wantsEventStream reports whether the caller asked for Server-Sent Events
+func wantsEventStream( request *http.Request ) bool {
+  return strings.Contains( request.Header.Get( "Accept" ), "text/event-stream" )
+}
+
+// streamExecute runs a command and streams its output until it completes or the timeout elapses
+func ( server *serverInstance ) streamExecute( writer http.ResponseWriter,
+                                               request *http.Request,
+                                               command string,
+                                               timeout time.Duration,
+                                               options shell.Options ) {
+  target := server.requestShell( request )
+  id, err := target.Submit( command, options )
+  if err != nil {
+    server.writeExecuteError( writer, request, err )
+    return
+  }
+
+  stream := newEventStream( writer )
+  stream.start()
+
+  ctx, cancel := context.WithTimeout( request.Context(), timeout )
+  defer cancel()
+  followStream( ctx, stream, target, id )
+}
+
+func ( server *serverInstance ) handleOutputStream( writer http.ResponseWriter,
+                                                    request *http.Request ) {
+  target := server.requestShell( request )
+  stream := newEventStream( writer )
+  followStream( request.Context(), stream, target, 0 )
+
+  // nothing was streamed because there is no command to follow
+  if !stream.started {
+    writeError( writer, request, http.StatusNotFound, errorCodeNoCommand,
+                "There is no command to follow.", string( target.State() ) )
+  }
+}
+
+// followStream streams the output of the command with the ID, or the current command if the ID is
+// zero, followed by an exit event; a timeout event is sent if the context deadline passes while the
+// command is still running or queued and a restarted event if the shell failed and was restarted
+func followStream( ctx context.Context, stream *eventStream, target *shell.Shell, id uint64 ) {
+  write := func( chunk string ) {
+    stream.send( "output", outputEvent{ Output: chunk } )
+  }
+  var result shell.Result
+  var err error
+  if id == 0 {
+    result, err = target.Follow( ctx, write )
+  } else {
+    result, err = target.FollowCommand( ctx, id, write )
+  }
+
+  if err != nil {
+    state := string( target.State() )
+    if errors.Is( err, context.DeadlineExceeded ) {
+      stream.send( "timeout", errorResponse{
+        Error:   errorCodeTimeout,
+        Message: "The command timed out. The shell is busy and the command is still running.",
+        State:   state,
+      } )
+    } else if errors.Is( err, shell.ErrNoCommand ) && !stream.started {
+      return
+    } else if errors.Is( err, shell.ErrRestarted ) {
+      stream.send( "restarted", errorResponse{ Error: errorCodeRestarted, Message: err.Error(), State: state } )
+    } else if !errors.Is( err, context.Canceled ) {
+      stream.send( "error", errorResponse{ Error: errorCodeInternal, Message: err.Error(), State: state } )
+    }
+    return
+  }
+
+  event := exitEvent{
+    JobID:      result.ID,
+    ExitCode:   result.ExitCode,
+    DurationMs: result.Duration.Milliseconds(),
+    State:      string( target.State() ),
+  }
+  if result.SeparateStderr {
+    event.Stderr = &result.Stderr
+  }
+  stream.send( "exit", event )
+}
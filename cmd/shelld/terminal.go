@@ -0,0 +1,90 @@
+package main
+
+import (
+  "encoding/json"
+  "net/http"
+
+  "github.com/gorilla/websocket"
+)
+
+// terminalMessage is a control message sent by the client as a text frame; binary frames are raw input
+type terminalMessage struct {
+  Type string `json:"type"`
+  Data string `json:"data"`
+  Rows uint16 `json:"rows"`
+  Cols uint16 `json:"cols"`
+}
+
+// upgrader accepts any origin; access is gated by the shell key like every other endpoint
+var upgrader = websocket.Upgrader{
+  ReadBufferSize:  4096,
+  WriteBufferSize: 4096,
+  CheckOrigin:     func( request *http.Request ) bool { return true },
+}
+
+func ( server *serverInstance ) handleTerminal( writer http.ResponseWriter,
+                                                request *http.Request ) {
+  target := server.requestShell( request )
+  terminal, err := target.Attach()
+  if err != nil {
+    state := target.State()
+    writeError( writer, request, http.StatusConflict, errorCodeForState( state ), err.Error(), string( state ) )
+    return
+  }
+  defer terminal.Detach()
+
+  connection, err := upgrader.Upgrade( writer, request, nil )
+  if err != nil {
+    server.logger.Error( "Server | Terminal | The connection could not be upgraded.", "error", err )
+    return
+  }
+  defer connection.Close()
+
+  server.logger.Info( "Server | Terminal | A terminal has been attached." )
+
+  // the output pump is the only writer on the connection; it closes the connection when the shell
+  // goes away so the read loop below ends as well
+  go func() {
+    for chunk := range terminal.Output() {
+      if err := connection.WriteMessage( websocket.BinaryMessage, chunk ); err != nil {
+        break
+      }
+    }
+    connection.Close()
+  }()
+
+  for {
+    messageType, data, err := connection.ReadMessage()
+    if err != nil {
+      server.logger.Info( "Server | Terminal | The terminal has been detached." )
+      return
+    }
+    server.updateActivity()
+
+    if messageType == websocket.BinaryMessage {
+      if err := terminal.Write( data ); err != nil {
+        return
+      }
+      continue
+    }
+
+    var message terminalMessage
+    if err := json.Unmarshal( data, &message ); err != nil {
+      server.logger.Debug( "Server | Terminal | The control message is invalid.", "error", err )
+      continue
+    }
+
+    switch message.Type {
+    case "input":
+      if err := terminal.Write( []byte( message.Data ) ); err != nil {
+        return
+      }
+    case "resize":
+      if err := terminal.Resize( message.Rows, message.Cols ); err != nil {
+        server.logger.Debug( "Server | Terminal | The terminal could not be resized.", "error", err )
+      }
+    default:
+      server.logger.Debug( "Server | Terminal | The control message type is unknown.", "type", message.Type )
+    }
+  }
+}
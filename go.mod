@@ -5,4 +5,7 @@ go 1.22
 require (
 	github.com/BurntSushi/toml v1.6.0
 	github.com/creack/pty v1.1.24
+	github.com/gorilla/websocket v1.5.3
 )
+
+require golang.org/x/sys v0.30.0
@@ -1,8 +1,13 @@
 package config
 
 import (
+  "errors"
   "fmt"
   "os"
+  "os/user"
+  "path/filepath"
+  "regexp"
+  "strconv"
   "time"
 
   "github.com/BurntSushi/toml"
@@ -18,26 +23,75 @@ const (
   defaultIdleTimeout       = "30m"
   defaultShutdownTimeout   = "30s"
   defaultKillTimeout       = "5s"
+  defaultMaximumSessions   = 8
+  defaultOutputFilter      = "none"
+  defaultMaximumBuffered   = 16 * 1024 * 1024
+  defaultMaximumReturned   = 1024 * 1024
+  defaultHistorySize       = 100
+  defaultCgroupParent      = "/sys/fs/cgroup/shelld"
 )
 
+// defaultStateVariables are the environment variables reported by /state by default
+var defaultStateVariables = []string{ "HOME", "PATH", "USER", "VIRTUAL_ENV" }
+
+// variableNamePattern matches the environment variable names the shell can report
+var variableNamePattern = regexp.MustCompile( `^[A-Za-z_][A-Za-z0-9_]*$` )
+
 // Config holds all configuration for shelld
 type Config struct {
   Server  ServerConfig  `toml:"server"`
   Shell   ShellConfig   `toml:"shell"`
   Timeout TimeoutConfig `toml:"timeout"`
   Hooks   HooksConfig   `toml:"hooks"`
+  Limits  LimitsConfig  `toml:"limits"`
 }
 
 // ServerConfig holds HTTP server configuration
 type ServerConfig struct {
-  Port        int   `toml:"port"`
-  DieOnUnlock *bool `toml:"die_on_unlock"`
+  Port            int   `toml:"port"`
+  DieOnUnlock     *bool `toml:"die_on_unlock"`
+  MaximumSessions int   `toml:"maximum_sessions"`
 }
 
 // ShellConfig holds shell execution configuration
 type ShellConfig struct {
-  Command          string `toml:"command"`
-  WorkingDirectory string `toml:"working_directory"`
+  Command          string        `toml:"command"`
+  WorkingDirectory string        `toml:"working_directory"`
+  ExactOutput      bool          `toml:"exact_output"`
+  OutputFilter     string        `toml:"output_filter"`
+  MaximumBuffered  int           `toml:"maximum_buffered_bytes"`
+  MaximumReturned  int           `toml:"maximum_returned_bytes"`
+  HistorySize      *int          `toml:"history_size"`
+  QueueDepth       int           `toml:"queue_depth"`
+  AutoRestart      bool          `toml:"auto_restart"`
+  InitScript       string        `toml:"init_script"`
+  StateVariables   []string      `toml:"state_variables"`
+  Rlimits          RlimitsConfig `toml:"rlimits"`
+  User             string        `toml:"user"`
+  Group            string        `toml:"group"`
+  Groups           []string      `toml:"supplementary_groups"`
+
+  // resolved user, nil when the shell runs as the server's user
+  Credential *Credential `toml:"-"`
+}
+
+// Credential is the user, primary group and supplementary groups the shell runs as
+type Credential struct {
+  UID      uint32
+  GID      uint32
+  Groups   []uint32
+  Username string
+  Home     string
+}
+
+// RlimitsConfig holds the resource limits set on the shell; a limit that is not set is inherited
+// from shelld
+type RlimitsConfig struct {
+  OpenFiles *int64 `toml:"open_files"`
+  FileSize  *int64 `toml:"file_size_bytes"`
+  CoreSize  *int64 `toml:"core_size_bytes"`
+  CPUTime   *int64 `toml:"cpu_seconds"`
+  Processes *int64 `toml:"processes"`
 }
 
 // TimeoutConfig holds all timeout configuration
@@ -63,6 +117,14 @@ type HooksConfig struct {
   Unlock string `toml:"unlock"`
 }
 
+// LimitsConfig holds the cgroup v2 resource limits of the shell; a limit of zero is not applied
+type LimitsConfig struct {
+  CgroupParent string  `toml:"cgroup_parent"`
+  MemoryMax    int64   `toml:"memory_max_bytes"`
+  CPUMax       float64 `toml:"cpu_max"`
+  PIDsMax      int     `toml:"pids_max"`
+}
+
 // Load reads and parses a configuration file
 func Load( path string ) ( *Config, error ) {
   data, err := os.ReadFile( path )
@@ -85,6 +147,10 @@ func Load( path string ) ( *Config, error ) {
     return nil, err
   }
 
+  if err := resolveUser( cfg ); err != nil {
+    return nil, err
+  }
+
   return cfg, nil
 }
 
@@ -93,9 +159,30 @@ func applyDefaults( cfg *Config ) {
   if cfg.Server.Port == 0 {
     cfg.Server.Port = defaultPort
   }
+  if cfg.Server.MaximumSessions == 0 {
+    cfg.Server.MaximumSessions = defaultMaximumSessions
+  }
   if cfg.Shell.Command == "" {
     cfg.Shell.Command = defaultShell
   }
+  if cfg.Shell.OutputFilter == "" {
+    cfg.Shell.OutputFilter = defaultOutputFilter
+  }
+  if cfg.Shell.MaximumBuffered == 0 {
+    cfg.Shell.MaximumBuffered = defaultMaximumBuffered
+  }
+  if cfg.Shell.MaximumReturned == 0 {
+    cfg.Shell.MaximumReturned = defaultMaximumReturned
+  }
+  // a history size of 0 disables the history
+  if cfg.Shell.HistorySize == nil {
+    defaultSize := defaultHistorySize
+    cfg.Shell.HistorySize = &defaultSize
+  }
+  // an empty list reports no variables
+  if cfg.Shell.StateVariables == nil {
+    cfg.Shell.StateVariables = defaultStateVariables
+  }
   if cfg.Timeout.Command == "" {
     cfg.Timeout.Command = defaultCommandTimeout
   }
@@ -111,6 +198,9 @@ func applyDefaults( cfg *Config ) {
   if cfg.Timeout.Kill == "" {
     cfg.Timeout.Kill = defaultKillTimeout
   }
+  if cfg.Limits.CgroupParent == "" {
+    cfg.Limits.CgroupParent = defaultCgroupParent
+  }
   if cfg.Hooks.Shell == "" {
     cfg.Hooks.Shell = defaultHookShell
   }
@@ -157,5 +247,133 @@ func validate( cfg *Config ) error {
   if cfg.Server.Port < 1 || cfg.Server.Port > 65535 {
     return fmt.Errorf( "The server.port must be between 1 and 65535, but got %d.", cfg.Server.Port )
   }
+  if cfg.Server.MaximumSessions < 0 {
+    return fmt.Errorf( "The server.maximum_sessions cannot be negative, but got %d.", cfg.Server.MaximumSessions )
+  }
+  if cfg.Shell.MaximumBuffered < 0 {
+    return fmt.Errorf( "The shell.maximum_buffered_bytes cannot be negative, but got %d.", cfg.Shell.MaximumBuffered )
+  }
+  if cfg.Shell.MaximumReturned < 0 {
+    return fmt.Errorf( "The shell.maximum_returned_bytes cannot be negative, but got %d.", cfg.Shell.MaximumReturned )
+  }
+  if *cfg.Shell.HistorySize < 0 {
+    return fmt.Errorf( "The shell.history_size cannot be negative, but got %d.", *cfg.Shell.HistorySize )
+  }
+  if cfg.Shell.QueueDepth < 0 {
+    return fmt.Errorf( "The shell.queue_depth cannot be negative, but got %d.", cfg.Shell.QueueDepth )
+  }
+  for _, name := range cfg.Shell.StateVariables {
+    if !variableNamePattern.MatchString( name ) {
+      return fmt.Errorf( "The shell.state_variables must be variable names, but got %s.", name )
+    }
+  }
+  for name, limit := range map[string]*int64{
+    "open_files":      cfg.Shell.Rlimits.OpenFiles,
+    "file_size_bytes": cfg.Shell.Rlimits.FileSize,
+    "core_size_bytes": cfg.Shell.Rlimits.CoreSize,
+    "cpu_seconds":     cfg.Shell.Rlimits.CPUTime,
+    "processes":       cfg.Shell.Rlimits.Processes,
+  } {
+    if limit != nil && *limit < 0 {
+      return fmt.Errorf( "The shell.rlimits.%s cannot be negative, but got %d.", name, *limit )
+    }
+  }
+  if cfg.Limits.MemoryMax < 0 {
+    return fmt.Errorf( "The limits.memory_max_bytes cannot be negative, but got %d.", cfg.Limits.MemoryMax )
+  }
+  if cfg.Limits.CPUMax < 0 {
+    return fmt.Errorf( "The limits.cpu_max cannot be negative, but got %g.", cfg.Limits.CPUMax )
+  }
+  // cpu.max does not accept a quota below a hundredth of a CPU
+  if cfg.Limits.CPUMax > 0 && cfg.Limits.CPUMax < 0.01 {
+    return fmt.Errorf( "The limits.cpu_max must be at least 0.01, but got %g.", cfg.Limits.CPUMax )
+  }
+  if cfg.Limits.PIDsMax < 0 {
+    return fmt.Errorf( "The limits.pids_max cannot be negative, but got %d.", cfg.Limits.PIDsMax )
+  }
+  if !filepath.IsAbs( cfg.Limits.CgroupParent ) {
+    return fmt.Errorf( "The limits.cgroup_parent must be an absolute path, but got %s.", cfg.Limits.CgroupParent )
+  }
+  switch cfg.Shell.OutputFilter {
+  case "none", "strip", "render":
+  default:
+    return fmt.Errorf( "The shell.output_filter must be none, strip or render, but got %s.", cfg.Shell.OutputFilter )
+  }
   return nil
 }
+
+// resolveUser looks up the user and groups the shell runs as; the groups default to the primary
+// group and the supplementary groups of the user
+func resolveUser( cfg *Config ) error {
+  if cfg.Shell.User == "" {
+    if cfg.Shell.Group != "" || cfg.Shell.Groups != nil {
+      return fmt.Errorf( "The shell.group and shell.supplementary_groups require a shell.user." )
+    }
+    return nil
+  }
+
+  account, err := lookupUser( cfg.Shell.User )
+  if err != nil {
+    return fmt.Errorf( "The shell.user %s could not be found: %w", cfg.Shell.User, err )
+  }
+  uid, err := strconv.ParseUint( account.Uid, 10, 32 )
+  if err != nil {
+    return fmt.Errorf( "The shell.user %s has an invalid ID: %w", cfg.Shell.User, err )
+  }
+  credential := &Credential{ UID: uint32( uid ), Username: account.Username, Home: account.HomeDir }
+
+  group := cfg.Shell.Group
+  if group == "" {
+    group = account.Gid
+  }
+  if credential.GID, err = lookupGroup( group ); err != nil {
+    return fmt.Errorf( "The shell.group %s could not be found: %w", group, err )
+  }
+
+  groups := cfg.Shell.Groups
+  if groups == nil {
+    // a user without an account has no supplementary groups
+    groups, _ = account.GroupIds()
+  }
+  credential.Groups = make( []uint32, 0, len( groups ) )
+  for _, name := range groups {
+    gid, err := lookupGroup( name )
+    if err != nil {
+      return fmt.Errorf( "The shell.supplementary_groups entry %s could not be found: %w", name, err )
+    }
+    credential.Groups = append( credential.Groups, gid )
+  }
+
+  cfg.Shell.Credential = credential
+  return nil
+}
+
+// lookupUser looks up a user by name or ID; a numeric ID without an account, as is common in
+// containers, is a user whose primary group has the same ID and whose home is the root directory
+func lookupUser( name string ) ( *user.User, error ) {
+  if _, err := strconv.ParseUint( name, 10, 32 ); err != nil {
+    return user.Lookup( name )
+  }
+
+  account, err := user.LookupId( name )
+  var unknown user.UnknownUserIdError
+  if errors.As( err, &unknown ) {
+    return &user.User{ Uid: name, Gid: name, Username: name, HomeDir: "/" }, nil
+  }
+  return account, err
+}
+
+// lookupGroup returns the ID of a group given by name or ID; a numeric ID does not need a group
+// entry
+func lookupGroup( name string ) ( uint32, error ) {
+  if gid, err := strconv.ParseUint( name, 10, 32 ); err == nil {
+    return uint32( gid ), nil
+  }
+
+  group, err := user.LookupGroup( name )
+  if err != nil {
+    return 0, err
+  }
+  gid, err := strconv.ParseUint( group.Gid, 10, 32 )
+  return uint32( gid ), err
+}
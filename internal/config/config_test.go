@@ -41,15 +41,49 @@ func TestLoadWithDefaults( t *testing.T ) {
   if cfg.Hooks.Shell != defaultHookShell {
     t.Errorf( "The default hook shell should be %s, but got %s.", defaultHookShell, cfg.Hooks.Shell )
   }
+  if cfg.Server.MaximumSessions != defaultMaximumSessions {
+    t.Errorf( "The default maximum sessions should be %d, but got %d.", defaultMaximumSessions, cfg.Server.MaximumSessions )
+  }
+  if cfg.Shell.OutputFilter != defaultOutputFilter {
+    t.Errorf( "The default output filter should be %s, but got %s.", defaultOutputFilter, cfg.Shell.OutputFilter )
+  }
+  if cfg.Shell.MaximumBuffered != defaultMaximumBuffered {
+    t.Errorf( "The default maximum buffered bytes should be %d, but got %d.", defaultMaximumBuffered, cfg.Shell.MaximumBuffered )
+  }
+  if cfg.Shell.MaximumReturned != defaultMaximumReturned {
+    t.Errorf( "The default maximum returned bytes should be %d, but got %d.", defaultMaximumReturned, cfg.Shell.MaximumReturned )
+  }
+  if *cfg.Shell.HistorySize != defaultHistorySize {
+    t.Errorf( "The default history size should be %d, but got %d.", defaultHistorySize, *cfg.Shell.HistorySize )
+  }
+  if len( cfg.Shell.StateVariables ) != len( defaultStateVariables ) {
+    t.Errorf( "The default state variables should be %v, but got %v.", defaultStateVariables, cfg.Shell.StateVariables )
+  }
+  if cfg.Limits.CgroupParent != defaultCgroupParent {
+    t.Errorf( "The default cgroup parent should be %s, but got %s.", defaultCgroupParent, cfg.Limits.CgroupParent )
+  }
+  if cfg.Limits.MemoryMax != 0 || cfg.Limits.CPUMax != 0 || cfg.Limits.PIDsMax != 0 {
+    t.Errorf( "No limit should be set by default, but got %+v.", cfg.Limits )
+  }
+  if cfg.Shell.Credential != nil {
+    t.Errorf( "The shell should run as the server's user by default, but got %+v.", cfg.Shell.Credential )
+  }
 }
 
 func TestLoadWithCustomValues( t *testing.T ) {
   content := `
 [server]
 port = 9000
+maximum_sessions = 3
 
 [shell]
 command = "/bin/zsh"
+exact_output = true
+output_filter = "strip"
+maximum_buffered_bytes = 4096
+maximum_returned_bytes = 1024
+auto_restart = true
+init_script = "export PROJECT=shelld"
 
 [timeout]
 command = "10m"
@@ -73,9 +107,28 @@ unlock = "echo unlocking"
   if cfg.Server.Port != 9000 {
     t.Errorf( "The port should be 9000, but got %d.", cfg.Server.Port )
   }
+  if cfg.Server.MaximumSessions != 3 {
+    t.Errorf( "The maximum sessions should be 3, but got %d.", cfg.Server.MaximumSessions )
+  }
   if cfg.Shell.Command != "/bin/zsh" {
     t.Errorf( "The shell should be /bin/zsh, but got %s.", cfg.Shell.Command )
   }
+  if !cfg.Shell.ExactOutput {
+    t.Errorf( "The exact output should be enabled." )
+  }
+  if cfg.Shell.OutputFilter != "strip" {
+    t.Errorf( "The output filter should be strip, but got %s.", cfg.Shell.OutputFilter )
+  }
+  if cfg.Shell.MaximumBuffered != 4096 || cfg.Shell.MaximumReturned != 1024 {
+    t.Errorf( "The output limits should be 4096 and 1024, but got %d and %d.",
+              cfg.Shell.MaximumBuffered, cfg.Shell.MaximumReturned )
+  }
+  if !cfg.Shell.AutoRestart {
+    t.Errorf( "The automatic restart should be enabled." )
+  }
+  if cfg.Shell.InitScript != "export PROJECT=shelld" {
+    t.Errorf( "The init script should be 'export PROJECT=shelld', but got %s.", cfg.Shell.InitScript )
+  }
   if cfg.Timeout.Command != "10m" {
     t.Errorf( "The command timeout should be 10m, but got %s.", cfg.Timeout.Command )
   }
@@ -103,6 +156,238 @@ port = 99999
   }
 }
 
+func TestLoadInvalidMaximumSessions( t *testing.T ) {
+  content := `
+[server]
+maximum_sessions = -1
+`
+  path := writeTempConfig( t, content )
+
+  _, err := Load( path )
+  if err == nil {
+    t.Fatal( "The configuration should fail to load when the maximum sessions is negative." )
+  }
+}
+
+func TestLoadInvalidOutputFilter( t *testing.T ) {
+  content := `
+[shell]
+output_filter = "colour"
+`
+  path := writeTempConfig( t, content )
+
+  _, err := Load( path )
+  if err == nil {
+    t.Fatal( "The configuration should fail to load when the output filter is unknown." )
+  }
+}
+
+func TestLoadInvalidOutputLimits( t *testing.T ) {
+  content := `
+[shell]
+maximum_returned_bytes = -1
+`
+  path := writeTempConfig( t, content )
+
+  _, err := Load( path )
+  if err == nil {
+    t.Fatal( "The configuration should fail to load when an output limit is negative." )
+  }
+}
+
+func TestLoadDisabledHistory( t *testing.T ) {
+  content := `
+[shell]
+history_size = 0
+`
+  path := writeTempConfig( t, content )
+
+  cfg, err := Load( path )
+  if err != nil {
+    t.Fatalf( "The configuration could not be loaded: %v", err )
+  }
+  if *cfg.Shell.HistorySize != 0 {
+    t.Errorf( "A history size of 0 should disable the history, but got %d.", *cfg.Shell.HistorySize )
+  }
+}
+
+func TestLoadInvalidHistorySize( t *testing.T ) {
+  content := `
+[shell]
+history_size = -1
+`
+  path := writeTempConfig( t, content )
+
+  _, err := Load( path )
+  if err == nil {
+    t.Fatal( "The configuration should fail to load when the history size is negative." )
+  }
+}
+
+func TestLoadInvalidQueueDepth( t *testing.T ) {
+  content := `
+[shell]
+queue_depth = -1
+`
+  path := writeTempConfig( t, content )
+
+  _, err := Load( path )
+  if err == nil {
+    t.Fatal( "The configuration should fail to load when the queue depth is negative." )
+  }
+}
+
+func TestLoadStateVariables( t *testing.T ) {
+  content := `
+[shell]
+state_variables = []
+`
+  path := writeTempConfig( t, content )
+
+  cfg, err := Load( path )
+  if err != nil {
+    t.Fatalf( "The configuration could not be loaded: %v", err )
+  }
+  if len( cfg.Shell.StateVariables ) != 0 {
+    t.Errorf( "An empty list should report no variables, but got %v.", cfg.Shell.StateVariables )
+  }
+
+  content = `
+[shell]
+state_variables = [ "PATH", "$(reboot)" ]
+`
+  path = writeTempConfig( t, content )
+
+  if _, err := Load( path ); err == nil {
+    t.Error( "The configuration should fail to load when a state variable is not a variable name." )
+  }
+}
+
+func TestLoadLimits( t *testing.T ) {
+  content := `
+[limits]
+cgroup_parent = "/sys/fs/cgroup/agents"
+memory_max_bytes = 2147483648
+cpu_max = 1.5
+pids_max = 256
+`
+  path := writeTempConfig( t, content )
+
+  cfg, err := Load( path )
+  if err != nil {
+    t.Fatalf( "The configuration could not be loaded: %v", err )
+  }
+  if cfg.Limits.CgroupParent != "/sys/fs/cgroup/agents" || cfg.Limits.MemoryMax != 2147483648 ||
+     cfg.Limits.CPUMax != 1.5 || cfg.Limits.PIDsMax != 256 {
+    t.Errorf( "The limits should be loaded, but got %+v.", cfg.Limits )
+  }
+
+  for _, content := range []string{
+    "[limits]\nmemory_max_bytes = -1\n",
+    "[limits]\ncpu_max = -0.5\n",
+    "[limits]\ncpu_max = 0.001\n",
+    "[limits]\npids_max = -1\n",
+    "[limits]\ncgroup_parent = \"shelld\"\n",
+  } {
+    if _, err := Load( writeTempConfig( t, content ) ); err == nil {
+      t.Errorf( "The configuration should fail to load with invalid limits: %s", content )
+    }
+  }
+}
+
+func TestLoadRlimits( t *testing.T ) {
+  content := `
+[shell.rlimits]
+open_files = 1024
+core_size_bytes = 0
+processes = 512
+`
+  path := writeTempConfig( t, content )
+
+  cfg, err := Load( path )
+  if err != nil {
+    t.Fatalf( "The configuration could not be loaded: %v", err )
+  }
+  limits := cfg.Shell.Rlimits
+  if limits.OpenFiles == nil || *limits.OpenFiles != 1024 || limits.Processes == nil || *limits.Processes != 512 {
+    t.Errorf( "The open files and processes limits should be 1024 and 512, but got %+v.", limits )
+  }
+  // a limit of zero is set, unlike a missing one
+  if limits.CoreSize == nil || *limits.CoreSize != 0 {
+    t.Errorf( "The core size limit should be set to 0, but got %v.", limits.CoreSize )
+  }
+  if limits.FileSize != nil || limits.CPUTime != nil {
+    t.Errorf( "The limits that are not configured should not be set, but got %+v.", limits )
+  }
+
+  content = `
+[shell.rlimits]
+open_files = -1
+`
+  path = writeTempConfig( t, content )
+
+  if _, err := Load( path ); err == nil {
+    t.Error( "The configuration should fail to load when a resource limit is negative." )
+  }
+}
+
+func TestLoadUser( t *testing.T ) {
+  content := `
+[shell]
+user = "root"
+`
+  cfg, err := Load( writeTempConfig( t, content ) )
+  if err != nil {
+    t.Fatalf( "The configuration could not be loaded: %v", err )
+  }
+  credential := cfg.Shell.Credential
+  if credential == nil || credential.UID != 0 || credential.GID != 0 || credential.Username != "root" ||
+     credential.Home != "/root" {
+    t.Errorf( "The shell should run as root with its primary group, but got %+v.", credential )
+  }
+
+  // a numeric user needs no account and the groups can be given by name or ID
+  content = `
+[shell]
+user = "54321"
+group = "root"
+supplementary_groups = [ "4242" ]
+`
+  cfg, err = Load( writeTempConfig( t, content ) )
+  if err != nil {
+    t.Fatalf( "The configuration could not be loaded: %v", err )
+  }
+  credential = cfg.Shell.Credential
+  if credential == nil || credential.UID != 54321 || credential.GID != 0 || len( credential.Groups ) != 1 ||
+     credential.Groups[0] != 4242 || credential.Home != "/" {
+    t.Errorf( "The shell should run as 54321 in groups 0 and 4242, but got %+v.", credential )
+  }
+
+  content = `
+[shell]
+user = "54321"
+supplementary_groups = []
+`
+  cfg, err = Load( writeTempConfig( t, content ) )
+  if err != nil {
+    t.Fatalf( "The configuration could not be loaded: %v", err )
+  }
+  if credential := cfg.Shell.Credential; credential == nil || credential.GID != 54321 || len( credential.Groups ) != 0 {
+    t.Errorf( "The shell should run in group 54321 only, but got %+v.", credential )
+  }
+
+  for _, content := range []string{
+    "[shell]\nuser = \"shelld-missing-user\"\n",
+    "[shell]\nuser = \"root\"\ngroup = \"shelld-missing-group\"\n",
+    "[shell]\nuser = \"root\"\nsupplementary_groups = [ \"shelld-missing-group\" ]\n",
+    "[shell]\ngroup = \"root\"\n",
+  } {
+    if _, err := Load( writeTempConfig( t, content ) ); err == nil {
+      t.Errorf( "The configuration should fail to load with an invalid user: %s", content )
+    }
+  }
+}
+
 func TestLoadInvalidDuration( t *testing.T ) {
   content := `
 [timeout]
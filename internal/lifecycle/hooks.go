@@ -38,7 +38,8 @@ func ( hooks *Hooks ) RunUnlock( ctx context.Context, key string ) {
   hooks.run( ctx, "unlock", hooks.unlock, key )
 }
 
-// run executes a hook command in a separate process
+// run executes a hook command in a separate process; hooks run as the server's user, also when the
+// shell runs as another user
 func ( hooks *Hooks ) run( ctx context.Context, hookName string, command string, key string ) {
   if command == "" {
     return
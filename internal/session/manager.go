@@ -0,0 +1,160 @@
+package session
+
+import (
+  "context"
+  "crypto/rand"
+  "encoding/hex"
+  "fmt"
+  "log/slog"
+  "sort"
+  "sync"
+  "time"
+
+  "github.com/endless/shelld/internal/lifecycle"
+  "github.com/endless/shelld/internal/shell"
+)
+
+// ErrNotFound is returned when no session has the requested ID
+var ErrNotFound = fmt.Errorf( "The session does not exist." )
+
+// ErrLimitReached is returned when the maximum number of sessions is already open
+var ErrLimitReached = fmt.Errorf( "The maximum number of sessions has been reached." )
+
+// Session is a named shell owned by the key that created it
+type Session struct {
+  ID      string
+  Key     string
+  Shell   *shell.Shell
+  Created time.Time
+}
+
+// Manager holds the named shell sessions of the server
+type Manager struct {
+  mu       sync.Mutex
+  sessions map[string]*Session
+  starting int
+  maximum  int
+  newShell func() *shell.Shell
+  hooks    *lifecycle.Hooks
+  logger   *slog.Logger
+}
+
+// NewManager creates a session manager that creates shells with newShell and runs the lock and
+// unlock hooks around each session
+func NewManager( maximum int,
+                 newShell func() *shell.Shell,
+                 hooks *lifecycle.Hooks,
+                 logger *slog.Logger ) *Manager {
+  return &Manager{
+    sessions: make( map[string]*Session ),
+    maximum:  maximum,
+    newShell: newShell,
+    hooks:    hooks,
+    logger:   logger,
+  }
+}
+
+// Create starts a new shell session owned by the key; the lock hook only runs once a slot is
+// reserved and is followed by the unlock hook when the shell fails to start
+func ( manager *Manager ) Create( ctx context.Context, key string ) ( *Session, error ) {
+  // the slot is reserved before the shell starts so the lock is not held during startup
+  manager.mu.Lock()
+  if len( manager.sessions )+manager.starting >= manager.maximum {
+    manager.mu.Unlock()
+    return nil, ErrLimitReached
+  }
+  manager.starting++
+  manager.mu.Unlock()
+
+  session := &Session{
+    ID:      newID(),
+    Key:     key,
+    Shell:   manager.newShell(),
+    Created: time.Now(),
+  }
+  manager.hooks.RunLock( ctx, key )
+  err := session.Shell.Start()
+
+  manager.mu.Lock()
+  manager.starting--
+  if err == nil {
+    manager.sessions[session.ID] = session
+  }
+  manager.mu.Unlock()
+
+  if err != nil {
+    session.Shell.Unlock()
+    manager.hooks.RunUnlock( ctx, key )
+    return nil, err
+  }
+
+  manager.logger.Info( "Session | Create | The session has been created.", "session", session.ID )
+  return session, nil
+}
+
+// Get returns the session with the ID
+func ( manager *Manager ) Get( id string ) ( *Session, error ) {
+  manager.mu.Lock()
+  defer manager.mu.Unlock()
+
+  session, ok := manager.sessions[id]
+  if !ok {
+    return nil, ErrNotFound
+  }
+  return session, nil
+}
+
+// List returns the sessions owned by the key ordered by creation time
+func ( manager *Manager ) List( key string ) []*Session {
+  manager.mu.Lock()
+  defer manager.mu.Unlock()
+
+  var sessions []*Session
+  for _, session := range manager.sessions {
+    if session.Key == key {
+      sessions = append( sessions, session )
+    }
+  }
+  sort.Slice( sessions, func( i, j int ) bool {
+    return sessions[i].Created.Before( sessions[j].Created )
+  } )
+  return sessions
+}
+
+// Delete terminates the shell of the session, runs the unlock hook and removes it
+func ( manager *Manager ) Delete( ctx context.Context, id string ) error {
+  manager.mu.Lock()
+  session, ok := manager.sessions[id]
+  delete( manager.sessions, id )
+  manager.mu.Unlock()
+
+  if !ok {
+    return ErrNotFound
+  }
+
+  manager.logger.Info( "Session | Delete | The session is being deleted.", "session", id )
+  err := session.Shell.Unlock()
+  manager.hooks.RunUnlock( ctx, session.Key )
+  return err
+}
+
+// CloseAll terminates the shells of every session and runs their unlock hooks
+func ( manager *Manager ) CloseAll( ctx context.Context ) {
+  manager.mu.Lock()
+  sessions := manager.sessions
+  manager.sessions = make( map[string]*Session )
+  manager.mu.Unlock()
+
+  for _, session := range sessions {
+    manager.logger.Info( "Session | CloseAll | The session is being closed.", "session", session.ID )
+    session.Shell.Unlock()
+    manager.hooks.RunUnlock( ctx, session.Key )
+  }
+}
+
+// newID returns a random session ID
+func newID() string {
+  bytes := make( []byte, 16 )
+  rand.Read( bytes )
+  return hex.EncodeToString( bytes )
+}
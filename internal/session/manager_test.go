@@ -0,0 +1,198 @@
+package session
+
+import (
+  "context"
+  "log/slog"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+  "time"
+
+  "github.com/endless/shelld/internal/lifecycle"
+  "github.com/endless/shelld/internal/shell"
+)
+
+func newTestManager( t *testing.T, maximum int ) *Manager {
+  t.Helper()
+  return newHookedManager( t, maximum, "/bin/bash", "" )
+}
+
+// newHookedManager creates a manager whose shells run the command and whose lock and unlock hooks
+// append to the log file
+func newHookedManager( t *testing.T, maximum int, command string, hookLog string ) *Manager {
+  t.Helper()
+  logger := slog.New( slog.NewTextHandler( os.Stderr, &slog.HandlerOptions{
+    Level: slog.LevelError,
+  } ) )
+  var lock, unlock string
+  if hookLog != "" {
+    lock, unlock = "echo lock >> "+hookLog, "echo unlock >> "+hookLog
+  }
+  return NewManager( maximum, func() *shell.Shell {
+    return shell.NewShell( shell.Config{ Command: command, KillGracePeriod: 5*time.Second }, logger )
+  }, lifecycle.NewHooks( "/bin/bash", lock, unlock, logger ), logger )
+}
+
+// readHookLog returns the hooks that ran in order
+func readHookLog( t *testing.T, hookLog string ) string {
+  t.Helper()
+  data, _ := os.ReadFile( hookLog )
+  return strings.Join( strings.Fields( string( data ) ), " " )
+}
+
+func TestManagerCreateAndGet( t *testing.T ) {
+  manager := newTestManager( t, 2 )
+  defer manager.CloseAll( context.Background() )
+
+  session, err := manager.Create( context.Background(), "key" )
+  if err != nil {
+    t.Fatalf( "The session could not be created: %v", err )
+  }
+
+  if session.Shell.State() != shell.StateLocked {
+    t.Errorf( "The session shell should be locked, but got %s.", session.Shell.State() )
+  }
+
+  found, err := manager.Get( session.ID )
+  if err != nil || found != session {
+    t.Errorf( "The session should be found by its ID: %v", err )
+  }
+}
+
+func TestManagerSessionsAreIsolated( t *testing.T ) {
+  manager := newTestManager( t, 2 )
+  defer manager.CloseAll( context.Background() )
+
+  first, err := manager.Create( context.Background(), "key" )
+  if err != nil {
+    t.Fatalf( "The first session could not be created: %v", err )
+  }
+  second, err := manager.Create( context.Background(), "key" )
+  if err != nil {
+    t.Fatalf( "The second session could not be created: %v", err )
+  }
+
+  if _, err := first.Shell.Execute( "export SESSION_TEST=first", 5*time.Second, shell.Options{} ); err != nil {
+    t.Fatalf( "The command failed to run: %v", err )
+  }
+  result, err := second.Shell.Execute( "echo ${SESSION_TEST:-unset}", 5*time.Second, shell.Options{} )
+  if err != nil {
+    t.Fatalf( "The command failed to run: %v", err )
+  }
+  if result.Output != "unset" {
+    t.Errorf( "The variable should not leak between sessions, but got '%s'.", result.Output )
+  }
+}
+
+func TestManagerLimit( t *testing.T ) {
+  manager := newTestManager( t, 1 )
+  defer manager.CloseAll( context.Background() )
+
+  if _, err := manager.Create( context.Background(), "key" ); err != nil {
+    t.Fatalf( "The session could not be created: %v", err )
+  }
+  if _, err := manager.Create( context.Background(), "key" ); err != ErrLimitReached {
+    t.Errorf( "The second session should fail with ErrLimitReached, but got: %v", err )
+  }
+}
+
+func TestManagerDelete( t *testing.T ) {
+  manager := newTestManager( t, 1 )
+  defer manager.CloseAll( context.Background() )
+
+  session, err := manager.Create( context.Background(), "key" )
+  if err != nil {
+    t.Fatalf( "The session could not be created: %v", err )
+  }
+
+  if err := manager.Delete( context.Background(), session.ID ); err != nil {
+    t.Fatalf( "The session could not be deleted: %v", err )
+  }
+  if session.Shell.State() != shell.StateAvailable {
+    t.Errorf( "The deleted session shell should be available, but got %s.", session.Shell.State() )
+  }
+  if _, err := manager.Get( session.ID ); err != ErrNotFound {
+    t.Errorf( "The deleted session should not be found, but got: %v", err )
+  }
+  if err := manager.Delete( context.Background(), session.ID ); err != ErrNotFound {
+    t.Errorf( "Deleting twice should fail with ErrNotFound, but got: %v", err )
+  }
+
+  // the slot is free again
+  if _, err := manager.Create( context.Background(), "key" ); err != nil {
+    t.Errorf( "A session should be creatable after delete: %v", err )
+  }
+}
+
+func TestManagerList( t *testing.T ) {
+  manager := newTestManager( t, 3 )
+  defer manager.CloseAll( context.Background() )
+
+  first, _ := manager.Create( context.Background(), "one" )
+  manager.Create( context.Background(), "two" )
+  second, _ := manager.Create( context.Background(), "one" )
+
+  sessions := manager.List( "one" )
+  if len( sessions ) != 2 || sessions[0] != first || sessions[1] != second {
+    t.Errorf( "The sessions of the key should be listed in creation order, but got %v.", sessions )
+  }
+}
+
+func TestManagerHooksArePaired( t *testing.T ) {
+  hookLog := filepath.Join( t.TempDir(), "hooks" )
+  manager := newHookedManager( t, 1, "/bin/bash", hookLog )
+  defer manager.CloseAll( context.Background() )
+
+  session, err := manager.Create( context.Background(), "key" )
+  if err != nil {
+    t.Fatalf( "The session could not be created: %v", err )
+  }
+  // no hook runs when there is no free slot
+  if _, err := manager.Create( context.Background(), "key" ); err != ErrLimitReached {
+    t.Fatalf( "The second session should fail with ErrLimitReached, but got: %v", err )
+  }
+  if hooks := readHookLog( t, hookLog ); hooks != "lock" {
+    t.Errorf( "Only the lock hook of the first session should run, but got '%s'.", hooks )
+  }
+
+  manager.Delete( context.Background(), session.ID )
+  if hooks := readHookLog( t, hookLog ); hooks != "lock unlock" {
+    t.Errorf( "The unlock hook should run on delete, but got '%s'.", hooks )
+  }
+}
+
+func TestManagerFailedStartRunsUnlockHook( t *testing.T ) {
+  hookLog := filepath.Join( t.TempDir(), "hooks" )
+  manager := newHookedManager( t, 1, "/nonexistent/shell", hookLog )
+  defer manager.CloseAll( context.Background() )
+
+  if _, err := manager.Create( context.Background(), "key" ); err == nil {
+    t.Fatal( "The session should fail when its shell cannot start." )
+  }
+  if hooks := readHookLog( t, hookLog ); hooks != "lock unlock" {
+    t.Errorf( "The failed session should run the unlock hook after the lock hook, but got '%s'.", hooks )
+  }
+  if len( manager.sessions ) != 0 || manager.starting != 0 {
+    t.Errorf( "The failed session should free its slot, but got %d sessions and %d starting.",
+              len( manager.sessions ), manager.starting )
+  }
+}
+
+func TestManagerCloseAllRunsUnlockHooks( t *testing.T ) {
+  hookLog := filepath.Join( t.TempDir(), "hooks" )
+  manager := newHookedManager( t, 2, "/bin/bash", hookLog )
+
+  first, _ := manager.Create( context.Background(), "key" )
+  second, _ := manager.Create( context.Background(), "key" )
+  manager.CloseAll( context.Background() )
+
+  if hooks := readHookLog( t, hookLog ); hooks != "lock lock unlock unlock" {
+    t.Errorf( "Every session should run the unlock hook, but got '%s'.", hooks )
+  }
+  for _, closed := range []*Session{ first, second } {
+    if closed.Shell.State() != shell.StateAvailable {
+      t.Errorf( "The closed session shell should be available, but got %s.", closed.Shell.State() )
+    }
+  }
+}
@@ -0,0 +1,185 @@
+package shell
+
+import (
+  "bufio"
+  "errors"
+  "fmt"
+  "os"
+  "path/filepath"
+  "strconv"
+  "strings"
+  "sync/atomic"
+  "time"
+)
+
+// cpuPeriod is the cpu.max period in microseconds the CPU limit is expressed in
+const cpuPeriod = 100000
+
+// cgroupSequence numbers the cgroups created by this server
+var cgroupSequence atomic.Uint64
+
+// Limits are the resource limits of a cgroup v2 the shell, everything it starts and the background
+// processes run in; a zero limit is not applied and no cgroup is created without any limit
+type Limits struct {
+  CgroupParent string  // cgroup directory under which a cgroup is created for each shell
+  MemoryMax    int64   // memory.max in bytes
+  CPUMax       float64 // cpu.max in CPUs
+  PIDsMax      int     // pids.max
+}
+
+// enabled reports whether any limit is set
+func ( limits Limits ) enabled() bool {
+  return limits.MemoryMax > 0 || limits.CPUMax > 0 || limits.PIDsMax > 0
+}
+
+// LimitsUsage is the resource usage of the cgroup of the shell and the events its limits caused
+type LimitsUsage struct {
+  MemoryCurrent    int64
+  MemoryMax        int64
+  OOMKills         int64
+  CPUMax           float64
+  CPUThrottled     int64
+  CPUThrottledTime time.Duration
+  PIDsCurrent      int64
+  PIDsMax          int
+  PIDsRejected     int64
+}
+
+// cgroup is the cgroup created for a shell; the directory stays open so processes can be started
+// directly in it
+type cgroup struct {
+  path      string
+  directory *os.File
+  limits    Limits
+}
+
+// createCgroup creates a cgroup for a shell under the parent, enables the controllers the limits
+// need in the parent and applies the limits
+func createCgroup( limits Limits ) ( *cgroup, error ) {
+  if err := os.MkdirAll( limits.CgroupParent, 0o755 ); err != nil {
+    return nil, fmt.Errorf( "The cgroup parent could not be created: %w", err )
+  }
+
+  var controllers []string
+  if limits.MemoryMax > 0 {
+    controllers = append( controllers, "memory" )
+  }
+  if limits.CPUMax > 0 {
+    controllers = append( controllers, "cpu" )
+  }
+  if limits.PIDsMax > 0 {
+    controllers = append( controllers, "pids" )
+  }
+  for _, controller := range controllers {
+    err := os.WriteFile( filepath.Join( limits.CgroupParent, "cgroup.subtree_control" ),
+                         []byte( "+"+controller ), 0o644 )
+    if err != nil {
+      return nil, fmt.Errorf( "The %s controller could not be enabled in %s: %w", controller,
+                              limits.CgroupParent, err )
+    }
+  }
+
+  path := filepath.Join( limits.CgroupParent,
+                         fmt.Sprintf( "shelld-%d-%d", os.Getpid(), cgroupSequence.Add( 1 ) ) )
+  if err := os.Mkdir( path, 0o755 ); err != nil {
+    return nil, fmt.Errorf( "The cgroup could not be created: %w", err )
+  }
+  group := &cgroup{ path: path, limits: limits }
+
+  settings := map[string]string{}
+  if limits.MemoryMax > 0 {
+    settings["memory.max"] = strconv.FormatInt( limits.MemoryMax, 10 )
+  }
+  if limits.CPUMax > 0 {
+    settings["cpu.max"] = fmt.Sprintf( "%d %d", int64( limits.CPUMax*cpuPeriod ), cpuPeriod )
+  }
+  if limits.PIDsMax > 0 {
+    settings["pids.max"] = strconv.Itoa( limits.PIDsMax )
+  }
+  for name, value := range settings {
+    if err := os.WriteFile( filepath.Join( path, name ), []byte( value ), 0o644 ); err != nil {
+      group.remove()
+      return nil, fmt.Errorf( "The cgroup limit %s could not be set: %w", name, err )
+    }
+  }
+
+  directory, err := os.Open( path )
+  if err != nil {
+    group.remove()
+    return nil, fmt.Errorf( "The cgroup could not be opened: %w", err )
+  }
+  group.directory = directory
+  return group, nil
+}
+
+// fd returns the descriptor of the cgroup directory a process is started in
+func ( group *cgroup ) fd() int {
+  return int( group.directory.Fd() )
+}
+
+// remove kills every process left in the cgroup and removes it; the removal is retried while the
+// killed processes exit
+func ( group *cgroup ) remove() error {
+  if group.directory != nil {
+    group.directory.Close()
+    group.directory = nil
+  }
+
+  // cgroup.kill is missing before Linux 5.14, the processes are then expected to be gone already
+  os.WriteFile( filepath.Join( group.path, "cgroup.kill" ), []byte( "1" ), 0o644 )
+
+  var err error
+  for attempt := 0; attempt < 20; attempt++ {
+    if err = os.Remove( group.path ); err == nil || errors.Is( err, os.ErrNotExist ) {
+      return nil
+    }
+    time.Sleep( 50 * time.Millisecond )
+  }
+  return fmt.Errorf( "The cgroup %s could not be removed: %w", group.path, err )
+}
+
+// usage reads the resource usage and limit events of the cgroup; counters of a controller that is
+// not enabled are zero
+func ( group *cgroup ) usage() LimitsUsage {
+  usage := LimitsUsage{
+    MemoryMax: group.limits.MemoryMax,
+    CPUMax:    group.limits.CPUMax,
+    PIDsMax:   group.limits.PIDsMax,
+  }
+  usage.MemoryCurrent = group.readValue( "memory.current" )
+  usage.OOMKills = group.readKey( "memory.events", "oom_kill" )
+  usage.CPUThrottled = group.readKey( "cpu.stat", "nr_throttled" )
+  usage.CPUThrottledTime = time.Duration( group.readKey( "cpu.stat", "throttled_usec" ) ) * time.Microsecond
+  usage.PIDsCurrent = group.readValue( "pids.current" )
+  usage.PIDsRejected = group.readKey( "pids.events", "max" )
+  return usage
+}
+
+// readValue reads a file of the cgroup holding a single number
+func ( group *cgroup ) readValue( name string ) int64 {
+  data, err := os.ReadFile( filepath.Join( group.path, name ) )
+  if err != nil {
+    return 0
+  }
+  value, _ := strconv.ParseInt( strings.TrimSpace( string( data ) ), 10, 64 )
+  return value
+}
+
+// readKey reads a number from a file of the cgroup holding one key and value per line
+func ( group *cgroup ) readKey( name string, key string ) int64 {
+  file, err := os.Open( filepath.Join( group.path, name ) )
+  if err != nil {
+    return 0
+  }
+  defer file.Close()
+
+  scanner := bufio.NewScanner( file )
+  for scanner.Scan() {
+    fields := strings.Fields( scanner.Text() )
+    if len( fields ) == 2 && fields[0] == key {
+      value, _ := strconv.ParseInt( fields[1], 10, 64 )
+      return value
+    }
+  }
+  return 0
+}
@@ -0,0 +1,128 @@
+package shell
+
+import (
+  "errors"
+  "fmt"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+  "time"
+)
+
+// writeCgroupFile writes a file of the fake cgroup
+func writeCgroupFile( t *testing.T, group *cgroup, name string, content string ) {
+  t.Helper()
+  if err := os.WriteFile( filepath.Join( group.path, name ), []byte( content ), 0o644 ); err != nil {
+    t.Fatalf( "The cgroup file %s could not be written: %v", name, err )
+  }
+}
+
+func TestCreateCgroup( t *testing.T ) {
+  // a plain directory stands in for the cgroup filesystem
+  parent := filepath.Join( t.TempDir(), "shelld" )
+  group, err := createCgroup( Limits{ CgroupParent: parent, MemoryMax: 1 << 20, CPUMax: 1.5, PIDsMax: 64 } )
+  if err != nil {
+    t.Fatalf( "The cgroup could not be created: %v", err )
+  }
+  defer group.directory.Close()
+
+  if filepath.Dir( group.path ) != parent || !strings.HasPrefix( filepath.Base( group.path ), "shelld-" ) {
+    t.Errorf( "The cgroup should be created under %s, but got %s.", parent, group.path )
+  }
+  for name, expected := range map[string]string{
+    "memory.max": "1048576",
+    "cpu.max":    "150000 100000",
+    "pids.max":   "64",
+  } {
+    if data, _ := os.ReadFile( filepath.Join( group.path, name ) ); string( data ) != expected {
+      t.Errorf( "The %s should be '%s', but got '%s'.", name, expected, data )
+    }
+  }
+
+  writeCgroupFile( t, group, "memory.current", "524288\n" )
+  writeCgroupFile( t, group, "memory.events", "low 0\nhigh 0\nmax 12\noom 2\noom_kill 2\n" )
+  writeCgroupFile( t, group, "cpu.stat", "usage_usec 90000\nnr_periods 40\nnr_throttled 7\nthrottled_usec 35000\n" )
+  writeCgroupFile( t, group, "pids.current", "5\n" )
+  writeCgroupFile( t, group, "pids.events", "max 3\n" )
+
+  usage := group.usage()
+  expected := LimitsUsage{
+    MemoryCurrent:    524288,
+    MemoryMax:        1 << 20,
+    OOMKills:         2,
+    CPUMax:           1.5,
+    CPUThrottled:     7,
+    CPUThrottledTime: 35 * time.Millisecond,
+    PIDsCurrent:      5,
+    PIDsMax:          64,
+    PIDsRejected:     3,
+  }
+  if usage != expected {
+    t.Errorf( "The usage should be %+v, but got %+v.", expected, usage )
+  }
+}
+
+func TestCreateCgroupOnlyEnablesNeededControllers( t *testing.T ) {
+  parent := t.TempDir()
+  group, err := createCgroup( Limits{ CgroupParent: parent, PIDsMax: 16 } )
+  if err != nil {
+    t.Fatalf( "The cgroup could not be created: %v", err )
+  }
+  defer group.directory.Close()
+
+  // the fake controls file only keeps the last controller written
+  if data, _ := os.ReadFile( filepath.Join( parent, "cgroup.subtree_control" ) ); string( data ) != "+pids" {
+    t.Errorf( "Only the pids controller should be enabled, but got '%s'.", data )
+  }
+  for _, name := range []string{ "memory.max", "cpu.max" } {
+    if _, err := os.Stat( filepath.Join( group.path, name ) ); !errors.Is( err, os.ErrNotExist ) {
+      t.Errorf( "The %s should not be written without its limit: %v", name, err )
+    }
+  }
+}
+
+func TestShellStartWithUnusableCgroup( t *testing.T ) {
+  shell := newTestShell( t )
+  shell.limits = Limits{ CgroupParent: "/proc/shelld", PIDsMax: 16 }
+
+  if err := shell.Start(); err == nil {
+    shell.Unlock()
+    t.Fatal( "The shell should not start when its cgroup cannot be created." )
+  }
+  if shell.State() != StateAvailable {
+    t.Errorf( "The shell should still be available, but got %s.", shell.State() )
+  }
+}
+
+func TestShellLimits( t *testing.T ) {
+  controllers, err := os.ReadFile( "/sys/fs/cgroup/cgroup.controllers" )
+  if err != nil || os.Getuid() != 0 || !strings.Contains( string( controllers ), "pids" ) {
+    t.Skip( "A writable cgroup v2 hierarchy with the pids controller is required." )
+  }
+
+  shell := newTestShell( t )
+  shell.limits = Limits{ CgroupParent: "/sys/fs/cgroup/shelld-test", PIDsMax: 32 }
+  defer os.Remove( shell.limits.CgroupParent )
+
+  if err := shell.Start(); err != nil {
+    t.Fatalf( "The shell failed to start: %v", err )
+  }
+  path := shell.cgroup.path
+
+  membership, _ := os.ReadFile( fmt.Sprintf( "/proc/%d/cgroup", shell.Status().PID ) )
+  if !strings.Contains( string( membership ), filepath.Base( path ) ) {
+    t.Errorf( "The shell should run in %s, but is in '%s'.", path, membership )
+  }
+  status := shell.Status()
+  if status.Limits == nil || status.Limits.PIDsMax != 32 || status.Limits.PIDsCurrent == 0 {
+    t.Errorf( "The status should report the cgroup, but got %+v.", status.Limits )
+  }
+
+  if err := shell.Unlock(); err != nil {
+    t.Fatalf( "The shell failed to unlock: %v", err )
+  }
+  if _, err := os.Stat( path ); !errors.Is( err, os.ErrNotExist ) {
+    t.Errorf( "The cgroup should be removed on unlock: %v", err )
+  }
+}
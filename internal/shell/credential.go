@@ -0,0 +1,51 @@
+package shell
+
+import (
+  "fmt"
+  "os"
+  "os/exec"
+  "syscall"
+)
+
+// Credential is the user the shell and the background processes run as instead of the server's
+// user; the server needs the privileges to switch to it
+type Credential struct {
+  UID      uint32
+  GID      uint32
+  Groups   []uint32
+  Username string
+  Home     string
+}
+
+// apply makes the command run as the user with the user's home and name in its environment; the
+// environment of the command has to be set already
+func ( credential *Credential ) apply( cmd *exec.Cmd ) {
+  if credential == nil {
+    return
+  }
+
+  if cmd.SysProcAttr == nil {
+    cmd.SysProcAttr = &syscall.SysProcAttr{}
+  }
+  // the groups replace the server's supplementary groups, also when there are none
+  cmd.SysProcAttr.Credential = &syscall.Credential{
+    Uid:    credential.UID,
+    Gid:    credential.GID,
+    Groups: credential.Groups,
+  }
+
+  // later entries replace the server's values
+  cmd.Env = append( cmd.Env, "HOME="+credential.Home, "USER="+credential.Username,
+                    "LOGNAME="+credential.Username )
+}
+
+// own gives the file to the user, so the shell can use a file or terminal the server created
+func ( credential *Credential ) own( path string ) error {
+  if credential == nil {
+    return nil
+  }
+  if err := os.Chown( path, int( credential.UID ), int( credential.GID ) ); err != nil {
+    return fmt.Errorf( "The file could not be given to the shell user: %w", err )
+  }
+  return nil
+}
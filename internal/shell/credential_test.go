@@ -0,0 +1,55 @@
+package shell
+
+import (
+  "os"
+  "testing"
+  "time"
+)
+
+func TestShellCredential( t *testing.T ) {
+  if os.Getuid() != 0 {
+    t.Skip( "Running the shell as another user requires root." )
+  }
+
+  shell := newTestShell( t )
+  shell.workingDirectory = "/tmp"
+  shell.credential = &Credential{ UID: 65534, GID: 65534, Groups: []uint32{ 65533 }, Username: "nobody",
+                                  Home: "/nonexistent" }
+  openFiles, processes := int64( 64 ), int64( 128 )
+  shell.rlimits = Rlimits{ OpenFiles: &openFiles, Processes: &processes }
+  defer shell.Unlock()
+
+  if err := shell.Start(); err != nil {
+    t.Fatalf( "The shell failed to start: %v", err )
+  }
+
+  result, err := shell.Execute( "id -u; id -g; id -G; echo $HOME $USER $LOGNAME", 30*time.Second, Options{} )
+  if expected := "65534\n65534\n65534 65533\n/nonexistent nobody nobody"; err != nil || result.Output != expected {
+    t.Errorf( "The shell should run as nobody, but got '%s' ( %v ).", result.Output, err )
+  }
+
+  // the limits are set by the user itself, which needs no privileges to lower them
+  result, err = shell.Execute( "ulimit -n; ulimit -u", 30*time.Second, Options{} )
+  if err != nil || result.Output != "64\n128" {
+    t.Errorf( "The limits of nobody should be '64\\n128', but got '%s' ( %v ).", result.Output, err )
+  }
+
+  // the terminal and the stderr file are given to the user
+  result, err = shell.Execute( "stat -c %u $(tty)", 30*time.Second, Options{} )
+  if err != nil || result.Output != "65534" {
+    t.Errorf( "The terminal should belong to nobody, but got '%s' ( %v ).", result.Output, err )
+  }
+  result, err = shell.Execute( "echo failed >&2", 30*time.Second, Options{ SeparateStderr: true } )
+  if err != nil || result.Stderr != "failed" || result.ExitCode != 0 {
+    t.Errorf( "The stderr should be written by nobody, but got %+v ( %v ).", result, err )
+  }
+
+  // a background process runs as the same user
+  if _, err := shell.StartProcess( "identity", "id -u; echo $HOME", Options{} ); err != nil {
+    t.Fatalf( "The process failed to start: %v", err )
+  }
+  waitProcessExit( t, shell, "identity" )
+  if output, _ := shell.ProcessOutput( "identity" ); string( output ) != "65534\n/nonexistent\n" {
+    t.Errorf( "The process should run as nobody, but got '%s'.", output )
+  }
+}
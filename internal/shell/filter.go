@@ -0,0 +1,239 @@
+package shell
+
+import (
+  "fmt"
+  "regexp"
+  "strconv"
+  "strings"
+  "unicode/utf8"
+)
+
+// Filter selects how terminal escape sequences and control codes in the output are handled
+type Filter string
+
+const (
+  FilterNone   Filter = "none"   // output is returned as the terminal received it
+  FilterStrip  Filter = "strip"  // escape sequences and control codes are removed
+  FilterRender Filter = "render" // output is rendered the way a terminal would display it
+)
+
+// escapeSequence matches the escape sequences a terminal interprets: control sequences ( colours,
+// cursor movement ), operating system commands ( window titles, hyperlinks ) and two character escapes
+const escapeSequence = `\x1b(?:\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(?:\x07|\x1b\\)|[ -/]*[0-~])`
+
+var escapeSequencePattern = regexp.MustCompile( escapeSequence )
+
+var leadingEscapeSequencePattern = regexp.MustCompile( `^` + escapeSequence )
+
+// incompleteEscapePattern matches the beginning of an escape sequence at the end of the output; it is
+// held back while streaming until the rest of the sequence has been read
+var incompleteEscapePattern = regexp.MustCompile( `\x1b(?:\[[0-?]*[ -/]*|\][^\x07\x1b]*\x1b?|[ -/]*)$` )
+
+// maximumHeldEscape is the length of an incomplete escape sequence after which it is treated as text
+// while streaming; a stray escape would otherwise hold back all later output
+const maximumHeldEscape = 4096
+
+// maximumCursorPosition bounds the rows and columns the cursor can be moved to in addition to the
+// size of the output, so a single movement cannot make the rendered output grow without bound
+const maximumCursorPosition = 10000
+
+// controlCodePattern matches the control codes other than tab and line feed
+var controlCodePattern = regexp.MustCompile( `[\x00-\x08\x0b-\x1f\x7f]` )
+
+// ParseFilter returns the filter with the name; an empty name is no filter
+func ParseFilter( name string ) ( Filter, error ) {
+  switch Filter( name ) {
+  case "", FilterNone:
+    return FilterNone, nil
+  case FilterStrip, FilterRender:
+    return Filter( name ), nil
+  }
+  return FilterNone, fmt.Errorf( "%w The output filter '%s' is unknown.", ErrInvalidOptions, name )
+}
+
+// applyFilter filters the output of a completed command; line breaks are expected as \n
+func applyFilter( output string, filter Filter ) string {
+  switch filter {
+  case FilterStrip:
+    return stripControl( output )
+  case FilterRender:
+    return render( output )
+  }
+  return output
+}
+
+// applyStreamFilter filters a piece of output that is still being produced and returns the end that
+// may be the beginning of an escape sequence separately; it is filtered with the next piece.
+// Rendering needs the complete output so it is stripped instead
+func applyStreamFilter( output string, filter Filter ) ( string, string ) {
+  if filter != FilterStrip && filter != FilterRender {
+    return output, ""
+  }
+  held := ""
+  if location := incompleteEscapePattern.FindStringIndex( output ); location != nil {
+    output, held = output[:location[0]], output[location[0]:]
+  }
+  if len( held ) > maximumHeldEscape {
+    output, held = output+held, ""
+  }
+  return stripControl( output ), held
+}
+
+// stripControl removes escape sequences and control codes
+func stripControl( output string ) string {
+  return controlCodePattern.ReplaceAllString( escapeSequencePattern.ReplaceAllString( output, "" ), "" )
+}
+
+// render applies carriage returns, backspaces, line erasure and cursor movement to the output so
+// redrawn progress bars collapse to their final state
+func render( output string ) string {
+  screen := &screen{ lines: [][]rune{ nil }, limit: min( len( output ), maximumCursorPosition ) }
+
+  for len( output ) > 0 {
+    if output[0] == 0x1b {
+      sequence := leadingEscapeSequencePattern.FindString( output )
+      if sequence == "" {
+        // an escape that does not start a known sequence is dropped
+        output = output[1:]
+        continue
+      }
+      screen.escape( sequence )
+      output = output[len( sequence ):]
+      continue
+    }
+
+    character, size := utf8.DecodeRuneInString( output )
+    screen.put( character )
+    output = output[size:]
+  }
+
+  return screen.String()
+}
+
+// screen is the minimal terminal model output is rendered on; the cursor is moved no further than
+// the limit beyond the lines and columns that were written
+type screen struct {
+  lines  [][]rune
+  row    int
+  column int
+  limit  int
+}
+
+// put applies a character at the cursor
+func ( screen *screen ) put( character rune ) {
+  switch character {
+  case '\n':
+    screen.moveRow( 1 )
+    screen.column = 0
+  case '\r':
+    screen.column = 0
+  case '\b':
+    screen.column = max( screen.column-1, 0 )
+  default:
+    if ( character < 0x20 && character != '\t' ) || character == 0x7f {
+      return
+    }
+    line := screen.lines[screen.row]
+    for len( line ) < screen.column {
+      line = append( line, ' ' )
+    }
+    if screen.column < len( line ) {
+      line[screen.column] = character
+    } else {
+      line = append( line, character )
+    }
+    screen.lines[screen.row] = line
+    screen.column++
+  }
+}
+
+// escape applies the cursor movement, positioning and erasure control sequences; every other
+// sequence is dropped
+func ( screen *screen ) escape( sequence string ) {
+  if !strings.HasPrefix( sequence, "\x1b[" ) {
+    return
+  }
+
+  final := sequence[len( sequence )-1]
+  parameters := sequence[2 : len( sequence )-1]
+  parameter, _ := strconv.Atoi( strings.Split( parameters, ";" )[0] )
+  count := max( parameter, 1 )
+
+  switch final {
+  case 'A':
+    screen.row = max( screen.row-count, 0 )
+  case 'B':
+    screen.moveRow( screen.clampRow( screen.row+count ) - screen.row )
+  case 'C':
+    screen.column = screen.clampColumn( screen.column+count )
+  case 'D':
+    screen.column = max( screen.column-count, 0 )
+  case 'G':
+    screen.column = screen.clampColumn( count - 1 )
+  case 'H', 'f':
+    // rows are counted from the first line of the output since there is no fixed screen
+    position := strings.Split( parameters, ";" )
+    column := 1
+    if len( position ) > 1 {
+      column, _ = strconv.Atoi( position[1] )
+    }
+    screen.row = 0
+    screen.moveRow( screen.clampRow( count - 1 ) )
+    screen.column = screen.clampColumn( max( column, 1 ) - 1 )
+  case 'K':
+    screen.eraseLine( parameter )
+  case 'J':
+    if parameter == 0 {
+      screen.eraseLine( 0 )
+      screen.lines = screen.lines[:screen.row+1]
+    } else if parameter >= 2 {
+      screen.lines = [][]rune{ nil }
+      screen.row = 0
+    }
+  }
+}
+
+// moveRow moves the cursor down, adding lines below the last one as needed
+func ( screen *screen ) moveRow( count int ) {
+  screen.row += count
+  for len( screen.lines ) <= screen.row {
+    screen.lines = append( screen.lines, nil )
+  }
+}
+
+// clampRow limits the row the cursor is moved to; it can move to any line that was written
+func ( screen *screen ) clampRow( row int ) int {
+  return min( row, max( len( screen.lines )-1, screen.limit ) )
+}
+
+// clampColumn limits the column the cursor is moved to; it can move to any column of the line
+func ( screen *screen ) clampColumn( column int ) int {
+  return min( column, max( len( screen.lines[screen.row] ), screen.limit ) )
+}
+
+// eraseLine erases from the cursor to the end of the line ( 0 ), from the start of the line to the
+// cursor ( 1 ) or the whole line ( 2 )
+func ( screen *screen ) eraseLine( mode int ) {
+  line := screen.lines[screen.row]
+  switch mode {
+  case 0:
+    if screen.column < len( line ) {
+      screen.lines[screen.row] = line[:screen.column]
+    }
+  case 1:
+    for index := 0; index <= screen.column && index < len( line ); index++ {
+      line[index] = ' '
+    }
+  case 2:
+    screen.lines[screen.row] = nil
+  }
+}
+
+// String returns the rendered lines
+func ( screen *screen ) String() string {
+  lines := make( []string, len( screen.lines ) )
+  for index, line := range screen.lines {
+    lines[index] = string( line )
+  }
+  return strings.Join( lines, "\n" )
+}
@@ -0,0 +1,126 @@
+package shell
+
+import (
+  "errors"
+  "strings"
+  "testing"
+)
+
+func TestParseFilter( t *testing.T ) {
+  tests := []struct {
+    name     string
+    expected Filter
+  }{
+    { "", FilterNone },
+    { "none", FilterNone },
+    { "strip", FilterStrip },
+    { "render", FilterRender },
+  }
+
+  for _, test := range tests {
+    filter, err := ParseFilter( test.name )
+    if err != nil || filter != test.expected {
+      t.Errorf( "The filter '%s' should parse to %s, but got %s ( %v ).", test.name, test.expected, filter, err )
+    }
+  }
+
+  if _, err := ParseFilter( "colour" ); !errors.Is( err, ErrInvalidOptions ) {
+    t.Errorf( "An unknown filter should fail with ErrInvalidOptions, but got: %v", err )
+  }
+}
+
+func TestFilterStrip( t *testing.T ) {
+  tests := []struct {
+    output   string
+    expected string
+  }{
+    { "\x1b[31mred\x1b[0m plain", "red plain" },
+    { "\x1b[1;38;5;208mbold\x1b[m", "bold" },
+    { "\x1b]0;title\x07text", "text" },
+    { "\x1b]8;;https://example.com\x1b\\link\x1b]8;;\x1b\\", "link" },
+    { "\x1b(Bcharset", "charset" },
+    { "\x1b[?25lhidden\x1b[?25h", "hidden" },
+    { "10%\r\x1b[K20%", "10%20%" },
+    { "tab\tand\nline", "tab\tand\nline" },
+  }
+
+  for _, test := range tests {
+    if result := applyFilter( test.output, FilterStrip ); result != test.expected {
+      t.Errorf( "The stripped output of %q should be %q, but got %q.", test.output, test.expected, result )
+    }
+  }
+}
+
+func TestFilterRender( t *testing.T ) {
+  tests := []struct {
+    output   string
+    expected string
+  }{
+    { "\x1b[32mok\x1b[0m", "ok" },
+    { "10%\r20%\r100%\ndone", "100%\ndone" },
+    { "downloading 99%\r\x1b[Kfinished", "finished" },
+    { "abc\b\bX", "aXc" },
+    { "long line\rshort", "shortline" },
+    { "a\nb\n\x1b[2Aone\ntwo\n", "one\ntwo\n" },
+    { "x\x1b[3Cy", "x   y" },
+    { "abcdef\x1b[3G\x1b[K", "ab" },
+    { "keep\nclear\x1b[2J\x1b[Hnew", "new" },
+    { "a\n\nb\n", "a\n\nb\n" },
+  }
+
+  for _, test := range tests {
+    if result := applyFilter( test.output, FilterRender ); result != test.expected {
+      t.Errorf( "The rendered output of %q should be %q, but got %q.", test.output, test.expected, result )
+    }
+  }
+}
+
+func TestFilterRenderBoundsCursorMovement( t *testing.T ) {
+  // movement is clamped to the size of the output
+  if result := applyFilter( "a\x1b[50000000Bb", FilterRender ); result != "a"+strings.Repeat( "\n", 13 )+" b" {
+    t.Errorf( "Moving down should stop after 13 rows, but got %q.", result )
+  }
+  if result := applyFilter( "a\x1b[200000000Cb", FilterRender ); result != "a"+strings.Repeat( " ", 13 )+"b" {
+    t.Errorf( "Moving right should stop after 14 columns, but got %q.", result )
+  }
+
+  // and to a fixed screen for long output
+  output := strings.Repeat( "x", 1<<20 ) + "\r\x1b[999999999B\x1b[999999999Cy\x1b[999999999;999999999Hz"
+  result := applyFilter( output, FilterRender )
+  lines := strings.Split( result, "\n" )
+  if len( lines ) != maximumCursorPosition+1 || lines[maximumCursorPosition] != strings.Repeat( " ", maximumCursorPosition )+"yz" {
+    t.Errorf( "The cursor should stay on a screen of %d rows and columns, but got %d lines.",
+              maximumCursorPosition, len( lines ) )
+  }
+
+  // the cursor still reaches every line and column that was written
+  if result := applyFilter( strings.Repeat( "line\n", 20 ) + "\x1b[1;30Hx", FilterRender ); !strings.HasPrefix( result, "line"+strings.Repeat( " ", 25 )+"x\n" ) {
+    t.Errorf( "The cursor should move within the written output, but got %q.", result[:40] )
+  }
+}
+
+func TestFilterStream( t *testing.T ) {
+  tests := []struct {
+    output   string
+    filter   Filter
+    expected string
+    held     string
+  }{
+    { "\x1b[31mred\x1b[", FilterStrip, "red", "\x1b[" },
+    { "\x1b[31mred\x1b[0", FilterRender, "red", "\x1b[0" },
+    { "red\x1b", FilterStrip, "red", "\x1b" },
+    { "red\x1b]0;tit", FilterStrip, "red", "\x1b]0;tit" },
+    { "\x1b[31mred\x1b[0m", FilterStrip, "red", "" },
+    { "\x1b[31mred", FilterNone, "\x1b[31mred", "" },
+    // an escape that never ends is passed through once it is too long to be a sequence
+    { "red\x1b]" + strings.Repeat( "t", maximumHeldEscape ), FilterStrip, "red" + strings.Repeat( "t", maximumHeldEscape ), "" },
+  }
+
+  for _, test := range tests {
+    result, held := applyStreamFilter( test.output, test.filter )
+    if result != test.expected || held != test.held {
+      t.Errorf( "The streamed output of %q should be %q holding %q, but got %q holding %q.",
+                test.output, test.expected, test.held, result, held )
+    }
+  }
+}
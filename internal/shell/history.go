@@ -0,0 +1,199 @@
+package shell
+
+import (
+  "fmt"
+  "os"
+  "sync"
+  "time"
+)
+
+// ErrNotInHistory is returned when no command in the history has the requested ID
+var ErrNotInHistory = fmt.Errorf( "The command is not in the history." )
+
+// CommandStatus is the outcome of a command in the history
+type CommandStatus string
+
+const (
+  CommandQueued    CommandStatus = "queued"    // command waiting for the running command to complete
+  CommandRunning   CommandStatus = "running"   // command still executing
+  CommandCompleted CommandStatus = "completed" // command exited, possibly with a non-zero exit code
+  CommandFailed    CommandStatus = "failed"    // shell closed or failed before the command exited
+  CommandCancelled CommandStatus = "cancelled" // command removed from the queue before it started
+)
+
+// historyExcerptSize is the most output of a command kept in memory when its output file cannot be
+// written
+const historyExcerptSize = 4096
+
+// HistoryEntry is the record of a command executed by the shell; the start time is zero while the
+// command is queued and the result is nil until the command completes. The output of the result is
+// kept in a file and only read for a single record
+type HistoryEntry struct {
+  ID       uint64
+  Command  string
+  Status   CommandStatus
+  Started  time.Time
+  Ended    time.Time
+  Duration time.Duration
+  Result   *Result
+
+  outputFile   string // file holding the output followed by the stderr of the result
+  outputLength int    // length of the output in the file
+}
+
+// history keeps the records of the most recent commands, oldest first
+type history struct {
+  maximum int
+  entries []HistoryEntry
+}
+
+// newHistory creates a history of up to maximum commands; a maximum of zero or less disables the
+// history
+func newHistory( maximum int ) *history {
+  return &history{ maximum: maximum }
+}
+
+// add records a command that was submitted; the oldest record is dropped when the history is full
+func ( commands *history ) add( id uint64, command string ) {
+  if commands.maximum <= 0 {
+    return
+  }
+  if len( commands.entries ) == commands.maximum {
+    removeOutputFile( commands.entries[0] )
+    commands.entries = append( commands.entries[:0], commands.entries[1:]... )
+  }
+  commands.entries = append( commands.entries, HistoryEntry{
+    ID:      id,
+    Command: command,
+    Status:  CommandQueued,
+  } )
+}
+
+// start records that a submitted command started running
+func ( commands *history ) start( id uint64, started time.Time ) {
+  if entry := commands.find( id ); entry != nil && entry.Status == CommandQueued {
+    entry.Status = CommandRunning
+    entry.Started = started
+  }
+}
+
+// finish records the end of a queued or running command; a command that never started has no
+// duration. The output of the result stays in memory until storeOutput moves it to a file
+func ( commands *history ) finish( id uint64, status CommandStatus, result *Result ) {
+  entry := commands.find( id )
+  if entry == nil || ( entry.Status != CommandQueued && entry.Status != CommandRunning ) {
+    return
+  }
+  entry.Status = status
+  entry.Ended = time.Now()
+  if !entry.Started.IsZero() {
+    entry.Duration = entry.Ended.Sub( entry.Started )
+  }
+  if result != nil {
+    stored := *result
+    entry.Result = &stored
+  }
+}
+
+// storeOutput moves the output of the finished command with the ID to a file, or cuts it to an
+// excerpt if the file cannot be written; the caller holds the lock, which is released while the file
+// is written so a slow disk does not hold up the shell
+func ( commands *history ) storeOutput( id uint64, lock sync.Locker ) {
+  entry := commands.find( id )
+  if entry == nil || entry.Result == nil || entry.outputFile != "" ||
+     ( entry.Result.Output == "" && entry.Result.Stderr == "" ) {
+    return
+  }
+  result := entry.Result
+
+  lock.Unlock()
+  path, err := writeOutputFile( result.Output + result.Stderr )
+  lock.Lock()
+
+  // the record may have been dropped or cleared while the file was written
+  entry = commands.find( id )
+  if entry == nil || entry.Result != result {
+    if err == nil {
+      os.Remove( path )
+    }
+    return
+  }
+  stored := *result
+  if err == nil {
+    stored.Output, stored.Stderr = "", ""
+    entry.outputFile, entry.outputLength = path, len( result.Output )
+  } else {
+    stored.Output, _ = truncateMiddle( result.Output, historyExcerptSize )
+    stored.Stderr, _ = truncateMiddle( result.Stderr, historyExcerptSize )
+    stored.Truncated = stored.Truncated || stored.Output != result.Output || stored.Stderr != result.Stderr
+  }
+  entry.Result = &stored
+}
+
+// clear removes the records and their output files
+func ( commands *history ) clear() {
+  for _, entry := range commands.entries {
+    removeOutputFile( entry )
+  }
+  commands.entries = nil
+}
+
+// find returns the record of the command with the ID or nil if it is not in the history
+func ( commands *history ) find( id uint64 ) *HistoryEntry {
+  for index := len( commands.entries ) - 1; index >= 0; index-- {
+    if commands.entries[index].ID == id {
+      return &commands.entries[index]
+    }
+  }
+  return nil
+}
+
+// list returns a copy of the records, oldest first
+func ( commands *history ) list() []HistoryEntry {
+  return append( []HistoryEntry{}, commands.entries... )
+}
+
+// get returns the record of the command with the ID with the output of its result read back
+func ( commands *history ) get( id uint64 ) ( HistoryEntry, error ) {
+  entry := commands.find( id )
+  if entry == nil {
+    return HistoryEntry{}, ErrNotInHistory
+  }
+  if entry.outputFile == "" {
+    return *entry, nil
+  }
+
+  data, err := os.ReadFile( entry.outputFile )
+  if err != nil {
+    return HistoryEntry{}, fmt.Errorf( "The output of the command could not be read: %w", err )
+  }
+  loaded := *entry
+  result := *entry.Result
+  result.Output, result.Stderr = string( data[:entry.outputLength] ), string( data[entry.outputLength:] )
+  loaded.Result = &result
+  return loaded, nil
+}
+
+// writeOutputFile writes the output of a command to a new file and returns its path
+func writeOutputFile( output string ) ( string, error ) {
+  file, err := os.CreateTemp( "", "shelld-history-*" )
+  if err != nil {
+    return "", err
+  }
+  _, err = file.WriteString( output )
+  if closeErr := file.Close(); err == nil {
+    err = closeErr
+  }
+  if err != nil {
+    os.Remove( file.Name() )
+    return "", err
+  }
+  return file.Name(), nil
+}
+
+// removeOutputFile removes the output file of the record if it has one
+func removeOutputFile( entry HistoryEntry ) {
+  if entry.outputFile != "" {
+    os.Remove( entry.outputFile )
+  }
+}
@@ -0,0 +1,99 @@
+package shell
+
+import (
+  "errors"
+  "os"
+  "sync"
+  "testing"
+  "time"
+)
+
+func TestHistoryDropsOldest( t *testing.T ) {
+  commands := newHistory( 2 )
+  commands.add( 1, "one" )
+  commands.add( 2, "two" )
+  commands.add( 3, "three" )
+
+  entries := commands.list()
+  if len( entries ) != 2 || entries[0].ID != 2 || entries[1].ID != 3 {
+    t.Errorf( "The history should keep the newest commands, but got %+v.", entries )
+  }
+}
+
+func TestHistoryFinish( t *testing.T ) {
+  commands := newHistory( 2 )
+  commands.add( 1, "one" )
+  commands.start( 1, time.Now() )
+
+  result := &Result{ ExitCode: 1 }
+  commands.finish( 1, CommandCompleted, result )
+  commands.finish( 1, CommandFailed, nil )
+
+  entry, err := commands.get( 1 )
+  if err != nil || entry.Status != CommandCompleted || entry.Result == nil || entry.Result.ExitCode != 1 {
+    t.Errorf( "A finished command should keep its first outcome, but got %+v ( %v ).", entry, err )
+  }
+}
+
+func TestHistoryKeepsOutputInFiles( t *testing.T ) {
+  commands := newHistory( 1 )
+  commands.add( 1, "one" )
+  commands.start( 1, time.Now() )
+  commands.finish( 1, CommandCompleted, &Result{ ID: 1, Output: "out", Stderr: "err" } )
+
+  // the output is kept in memory until it is stored, which releases the lock while it writes
+  if entry, _ := commands.get( 1 ); entry.Result.Output != "out" || entry.outputFile != "" {
+    t.Fatalf( "The output should be held until it is stored, but got %+v.", entry )
+  }
+  lock := &sync.Mutex{}
+  lock.Lock()
+  commands.storeOutput( 1, lock )
+  if lock.TryLock() {
+    t.Error( "The lock should be held again once the output is stored." )
+  }
+
+  // the list and the record in memory hold no output
+  stored := commands.list()[0]
+  if stored.Result.Output != "" || stored.Result.Stderr != "" || stored.outputFile == "" {
+    t.Fatalf( "The output should only be kept in a file, but got %+v.", stored )
+  }
+  entry, err := commands.get( 1 )
+  if err != nil || entry.Result.Output != "out" || entry.Result.Stderr != "err" {
+    t.Errorf( "The output should be read back from the file, but got %+v ( %v ).", entry.Result, err )
+  }
+
+  // the file is removed with the record
+  commands.add( 2, "two" )
+  if _, err := os.Stat( stored.outputFile ); !errors.Is( err, os.ErrNotExist ) {
+    t.Errorf( "The output file of the dropped command should be removed: %v", err )
+  }
+  commands.start( 2, time.Now() )
+  commands.finish( 2, CommandCompleted, &Result{ ID: 2, Output: "two" } )
+  commands.storeOutput( 2, lock )
+  path := commands.list()[0].outputFile
+  commands.clear()
+  if _, err := os.Stat( path ); !errors.Is( err, os.ErrNotExist ) || len( commands.list() ) != 0 {
+    t.Errorf( "Clearing the history should remove the output files: %v", err )
+  }
+}
+
+func TestHistoryCancelQueued( t *testing.T ) {
+  commands := newHistory( 2 )
+  commands.add( 1, "one" )
+  commands.finish( 1, CommandCancelled, nil )
+  commands.start( 1, time.Now() )
+
+  entry, err := commands.get( 1 )
+  if err != nil || entry.Status != CommandCancelled || !entry.Started.IsZero() || entry.Duration != 0 {
+    t.Errorf( "A cancelled command should not start, but got %+v ( %v ).", entry, err )
+  }
+}
+
+func TestHistoryDisabled( t *testing.T ) {
+  commands := newHistory( 0 )
+  commands.add( 1, "one" )
+
+  if len( commands.list() ) != 0 {
+    t.Error( "A history without a size should not keep commands." )
+  }
+}
@@ -0,0 +1,68 @@
+package shell
+
+import (
+  "fmt"
+  "regexp"
+  "sort"
+  "strings"
+)
+
+// ErrInvalidOptions is returned when the per-command options cannot be applied
+var ErrInvalidOptions = fmt.Errorf( "The command options are invalid." )
+
+// environmentNamePattern matches the names that can be exported to the shell
+var environmentNamePattern = regexp.MustCompile( `^[A-Za-z_][A-Za-z0-9_]*$` )
+
+// Options holds per-command settings applied by the wrapper around the command
+type Options struct {
+  Environment      map[string]string
+  WorkingDirectory string
+  SeparateStderr   bool
+  ExactOutput      bool
+  Filter           Filter
+}
+
+// validate checks that the environment variables can be exported
+func ( options Options ) validate() error {
+  for name := range options.Environment {
+    if !environmentNamePattern.MatchString( name ) {
+      return fmt.Errorf( "%w The environment variable name '%s' is invalid.", ErrInvalidOptions, name )
+    }
+  }
+  return nil
+}
+
+// scope applies the per-command environment and working directory to the eval of the command in a
+// subshell so they do not leak into the persistent session
+func ( options Options ) scope( evalCmd string ) string {
+  if len( options.Environment ) == 0 && options.WorkingDirectory == "" {
+    return evalCmd
+  }
+  return fmt.Sprintf( "(%s%s)", optionsPrefix( options ), evalCmd )
+}
+
+// optionsPrefix returns the shell commands that apply the per-command options before the eval
+func optionsPrefix( options Options ) string {
+  var prefix strings.Builder
+
+  if options.WorkingDirectory != "" {
+    fmt.Fprintf( &prefix, "cd -- %s||exit;", quote( options.WorkingDirectory ) )
+  }
+
+  // names are sorted so the wrapper is deterministic
+  names := make( []string, 0, len( options.Environment ) )
+  for name := range options.Environment {
+    names = append( names, name )
+  }
+  sort.Strings( names )
+  for _, name := range names {
+    fmt.Fprintf( &prefix, "export %s=%s;", name, quote( options.Environment[name] ) )
+  }
+
+  return prefix.String()
+}
+
+// quote returns the value as a single quoted shell word
+func quote( value string ) string {
+  return "'" + strings.ReplaceAll( value, "'", `'\''` ) + "'"
+}
@@ -0,0 +1,65 @@
+package shell
+
+import (
+  "errors"
+  "testing"
+  "time"
+)
+
+func TestShellOptions( t *testing.T ) {
+  shell := newTestShell( t )
+  defer shell.Unlock()
+
+  if err := shell.Start(); err != nil {
+    t.Fatalf( "The shell failed to start: %v", err )
+  }
+
+  options := Options{
+    Environment:      map[string]string{ "OPTIONS_TEST": "it's set" },
+    WorkingDirectory: "/tmp",
+  }
+  result, err := shell.Execute( "echo \"$OPTIONS_TEST\"; pwd", 30*time.Second, options )
+  if err != nil {
+    t.Fatalf( "The command failed to run: %v", err )
+  }
+  if result.Output != "it's set\n/tmp" {
+    t.Errorf( "The output should reflect the options, but got '%s'.", result.Output )
+  }
+
+  // the options do not leak into the session
+  result, err = shell.Execute( "echo ${OPTIONS_TEST:-unset}", 30*time.Second, Options{} )
+  if err != nil {
+    t.Fatalf( "The command failed to run: %v", err )
+  }
+  if result.Output != "unset" {
+    t.Errorf( "The variable should be unset after the command, but got '%s'.", result.Output )
+  }
+
+  _, err = shell.Execute( "true", 30*time.Second, Options{ Environment: map[string]string{ "1BAD": "x" } } )
+  if !errors.Is( err, ErrInvalidOptions ) {
+    t.Errorf( "An invalid variable name should be rejected, but got: %v", err )
+  }
+
+  result, err = shell.Execute( "true", 30*time.Second, Options{ WorkingDirectory: "/nonexistent" } )
+  if err != nil {
+    t.Fatalf( "The command failed to run: %v", err )
+  }
+  if result.ExitCode == 0 {
+    t.Error( "A missing working directory should fail the command." )
+  }
+}
+
+func TestOptionsScope( t *testing.T ) {
+  if scoped := ( Options{} ).scope( "eval x" ); scoped != "eval x" {
+    t.Errorf( "A command without options should not run in a subshell, but got %q.", scoped )
+  }
+
+  options := Options{
+    Environment:      map[string]string{ "B": "it's", "A": "1" },
+    WorkingDirectory: "/my dir",
+  }
+  expected := `(cd -- '/my dir'||exit;export A='1';export B='it'\''s';eval x)`
+  if scoped := options.scope( "eval x" ); scoped != expected {
+    t.Errorf( "The options should be applied in a subshell as %q, but got %q.", expected, scoped )
+  }
+}
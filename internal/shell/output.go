@@ -0,0 +1,202 @@
+package shell
+
+import (
+  "bytes"
+  "fmt"
+  "io"
+  "os"
+  "strings"
+  "unicode/utf8"
+)
+
+// outputReadSize is the most output a follower reads at a time
+const outputReadSize = 64 * 1024
+
+// OutputLimits bounds the output of a command held in memory and returned to the caller; zero is
+// no limit
+type OutputLimits struct {
+  MaximumBuffered int // bytes kept in memory, beyond this only the head and tail are kept
+  MaximumReturned int // bytes returned, beyond this the middle is replaced with a notice
+}
+
+// outputStore holds the output of a command with the terminal line breaks converted; beyond the
+// buffer limit only the head and the tail are kept in memory and the complete output is spilled to
+// a file
+type outputStore struct {
+  maximum int
+  head    []byte
+  tail    []byte
+  total   int64
+  spill   *os.File
+}
+
+// memoryOutput is output that was never spilled
+type memoryOutput struct {
+  *bytes.Reader
+}
+
+// Close has nothing to release
+func ( output memoryOutput ) Close() error {
+  return nil
+}
+
+// newOutputStore creates an output store that keeps up to maximum bytes in memory
+func newOutputStore( maximum int ) *outputStore {
+  return &outputStore{ maximum: maximum }
+}
+
+// write appends output; once it no longer fits in memory the output written so far is moved to the
+// spill file, the first half of the memory is kept as the head and the rest is used for the tail
+func ( store *outputStore ) write( data []byte ) error {
+  store.total += int64( len( data ) )
+
+  if store.spill == nil {
+    if store.maximum <= 0 || len( store.head )+len( data ) <= store.maximum {
+      store.head = append( store.head, data... )
+      return nil
+    }
+
+    spill, err := os.CreateTemp( "", "shelld-output-*" )
+    if err != nil {
+      return fmt.Errorf( "The output spill file could not be created: %w", err )
+    }
+    store.spill = spill
+    if _, err := spill.Write( store.head ); err != nil {
+      return fmt.Errorf( "The output could not be spilled: %w", err )
+    }
+
+    headLength := min( len( store.head ), store.maximum/2 )
+    store.tail = append( store.tail, store.head[headLength:]... )
+    store.head = store.head[:headLength:headLength]
+  }
+
+  if _, err := store.spill.Write( data ); err != nil {
+    return fmt.Errorf( "The output could not be spilled: %w", err )
+  }
+
+  store.tail = append( store.tail, data... )
+  if keep := store.maximum - len( store.head ); len( store.tail ) > keep {
+    store.tail = append( store.tail[:0], store.tail[len( store.tail )-keep:]... )
+  }
+  return nil
+}
+
+// omitted returns the number of bytes between the head and the tail that are not held in memory
+func ( store *outputStore ) omitted() int64 {
+  return store.total - int64( len( store.head ) ) - int64( len( store.tail ) )
+}
+
+// readAt returns up to length bytes of the output starting at the offset
+func ( store *outputStore ) readAt( offset int64, length int ) ( []byte, error ) {
+  if offset >= store.total {
+    return nil, nil
+  }
+
+  if store.spill == nil {
+    end := min( offset+int64( length ), int64( len( store.head ) ) )
+    return store.head[offset:end], nil
+  }
+
+  data := make( []byte, min( int64( length ), store.total-offset ) )
+  bytesRead, err := store.spill.ReadAt( data, offset )
+  if err != nil && err != io.EOF {
+    return nil, fmt.Errorf( "The spilled output could not be read: %w", err )
+  }
+  return data[:bytesRead], nil
+}
+
+// open returns a reader of the complete output written so far
+func ( store *outputStore ) open() ( io.ReadSeekCloser, error ) {
+  if store.spill == nil {
+    return memoryOutput{ bytes.NewReader( bytes.Clone( store.head ) ) }, nil
+  }
+
+  file, err := os.Open( store.spill.Name() )
+  if err != nil {
+    return nil, fmt.Errorf( "The spilled output could not be opened: %w", err )
+  }
+  return file, nil
+}
+
+// close removes the spill file
+func ( store *outputStore ) close() {
+  if store.spill != nil {
+    store.spill.Close()
+    os.Remove( store.spill.Name() )
+    store.spill = nil
+  }
+}
+
+// readHeadAndTail reads a file; a file larger than maximum bytes is read as its first and last
+// maximum / 2 bytes and the number of bytes left out is returned
+func readHeadAndTail( path string, maximum int ) ( []byte, int64, error ) {
+  file, err := os.Open( path )
+  if err != nil {
+    return nil, 0, err
+  }
+  defer file.Close()
+
+  info, err := file.Stat()
+  if err != nil {
+    return nil, 0, err
+  }
+  size := info.Size()
+  if maximum <= 0 || size <= int64( maximum ) {
+    data, err := io.ReadAll( file )
+    return data, 0, err
+  }
+
+  half := maximum / 2
+  data := make( []byte, maximum )
+  if _, err := file.ReadAt( data[:half], 0 ); err != nil {
+    return nil, 0, err
+  }
+  if _, err := file.ReadAt( data[half:], size-int64( maximum-half ) ); err != nil {
+    return nil, 0, err
+  }
+  return data, size - int64( maximum ), nil
+}
+
+// truncateMiddle keeps the head and tail of output longer than maximum bytes and replaces the middle
+// with a notice
+func truncateMiddle( output string, maximum int ) ( string, bool ) {
+  if maximum <= 0 || len( output ) <= maximum {
+    return output, false
+  }
+  half := maximum / 2
+  return joinTruncated( output[:half], output[len( output )-half:], int64( len( output )-2*half ) ), true
+}
+
+// joinTruncated joins the head and the tail of output whose middle of omitted bytes was left out
+// with a notice; the cuts are moved to the nearest line breaks, or to a character boundary when the
+// head or tail has no line break of its own, so neither a character nor a line with the escape
+// sequences in it is split
+func joinTruncated( head string, tail string, omitted int64 ) string {
+  headEnd := strings.LastIndexByte( head, '\n' )
+  if headEnd < 0 {
+    headEnd = len( head )
+    start := headEnd
+    for start > 0 && headEnd-start < utf8.UTFMax && !utf8.RuneStart( head[start-1] ) {
+      start--
+    }
+    if start > 0 && !utf8.FullRuneInString( head[start-1:] ) {
+      headEnd = start - 1
+    }
+  }
+
+  tailStart := strings.IndexByte( tail, '\n' ) + 1
+  if tailStart == 0 || tailStart == len( tail ) {
+    tailStart = 0
+    for tailStart < len( tail ) && tailStart < utf8.UTFMax && !utf8.RuneStart( tail[tailStart] ) {
+      tailStart++
+    }
+  }
+
+  omitted += int64( len( head )-headEnd+tailStart )
+  return head[:headEnd] + truncationNotice( omitted ) + tail[tailStart:]
+}
+
+// truncationNotice marks where output was left out
+func truncationNotice( omitted int64 ) string {
+  return fmt.Sprintf( "\n[... %d bytes truncated ...]\n", omitted )
+}
@@ -0,0 +1,80 @@
+package shell
+
+import (
+  "io"
+  "strings"
+  "testing"
+  "unicode/utf8"
+)
+
+func TestOutputStoreInMemory( t *testing.T ) {
+  store := newOutputStore( 16 )
+  defer store.close()
+
+  store.write( []byte( "hello " ) )
+  store.write( []byte( "world" ) )
+
+  if store.spill != nil || store.omitted() != 0 || string( store.head ) != "hello world" {
+    t.Errorf( "Output within the limit should stay in memory, but got head '%s'.", store.head )
+  }
+
+  data, err := store.readAt( 6, 3 )
+  if err != nil || string( data ) != "wor" {
+    t.Errorf( "The output should be readable at an offset, but got '%s' ( %v ).", data, err )
+  }
+}
+
+func TestOutputStoreSpill( t *testing.T ) {
+  store := newOutputStore( 8 )
+  defer store.close()
+
+  for _, piece := range []string{ "0123", "4567", "89ab", "cdef" } {
+    if err := store.write( []byte( piece ) ); err != nil {
+      t.Fatalf( "The output could not be written: %v", err )
+    }
+  }
+
+  if string( store.head ) != "0123" || string( store.tail ) != "cdef" || store.omitted() != 8 {
+    t.Errorf( "The head and tail should be kept, but got '%s' and '%s' omitting %d.",
+              store.head, store.tail, store.omitted() )
+  }
+
+  data, err := store.readAt( 6, 4 )
+  if err != nil || string( data ) != "6789" {
+    t.Errorf( "The omitted output should be read from the spill file, but got '%s' ( %v ).", data, err )
+  }
+
+  reader, err := store.open()
+  if err != nil {
+    t.Fatalf( "The output could not be opened: %v", err )
+  }
+  defer reader.Close()
+  complete, _ := io.ReadAll( reader )
+  if string( complete ) != "0123456789abcdef" {
+    t.Errorf( "The complete output should be spilled, but got '%s'.", complete )
+  }
+}
+
+func TestTruncateMiddle( t *testing.T ) {
+  output, truncated := truncateMiddle( "short", 10 )
+  if truncated || output != "short" {
+    t.Errorf( "Output within the limit should not be truncated, but got '%s'.", output )
+  }
+
+  output, truncated = truncateMiddle( strings.Repeat( "a", 10 )+strings.Repeat( "b", 10 ), 10 )
+  if !truncated || output != "aaaaa\n[... 10 bytes truncated ...]\nbbbbb" {
+    t.Errorf( "The middle of the output should be replaced, but got '%s'.", output )
+  }
+
+  // the cuts move to line breaks so the lines that are kept are complete
+  output, _ = truncateMiddle( "one\ntwo\nthree\nfour\nfive", 16 )
+  if output != "one\ntwo\n[... 12 bytes truncated ...]\nfive" {
+    t.Errorf( "The output should be cut at line breaks, but got '%s'.", output )
+  }
+
+  // a line without a break is cut between characters
+  output, _ = truncateMiddle( strings.Repeat( "é", 10 ), 10 )
+  if !utf8.ValidString( output ) || output != "éé\n[... 12 bytes truncated ...]\néé" {
+    t.Errorf( "The output should not split a character, but got '%q'.", output )
+  }
+}
@@ -0,0 +1,151 @@
+package shell
+
+import (
+  "fmt"
+  "os"
+  "strconv"
+  "strings"
+  "syscall"
+  "time"
+)
+
+// clockTicks is the number of clock ticks per second the CPU times in /proc are counted in; USER_HZ
+// is 100 on every architecture Go supports
+const clockTicks = 100
+
+// processStat holds the fields of /proc/<pid>/stat used by the shell
+type processStat struct {
+  Name         string
+  State        string
+  ParentID     int
+  ProcessGroup int
+  CPUTime      time.Duration
+  ResidentSize int64
+}
+
+// readProcessStat reads the name, state, parent, process group, user and system CPU time and
+// resident memory in bytes of the process
+func readProcessStat( pid int ) ( processStat, error ) {
+  data, err := os.ReadFile( fmt.Sprintf( "/proc/%d/stat", pid ) )
+  if err != nil {
+    return processStat{}, err
+  }
+
+  // the command name is in parentheses and may itself contain spaces and parentheses
+  content := string( data )
+  opening := strings.IndexByte( content, '(' )
+  closing := strings.LastIndexByte( content, ')' )
+  if opening < 0 || closing < opening {
+    return processStat{}, fmt.Errorf( "The stat of process %d is malformed.", pid )
+  }
+  fields := strings.Fields( content[closing+1:] )
+  if len( fields ) < 22 {
+    return processStat{}, fmt.Errorf( "The stat of process %d is malformed.", pid )
+  }
+
+  parentID, _ := strconv.Atoi( fields[1] )
+  processGroup, _ := strconv.Atoi( fields[2] )
+  userTicks, _ := strconv.ParseInt( fields[11], 10, 64 )
+  systemTicks, _ := strconv.ParseInt( fields[12], 10, 64 )
+  residentPages, _ := strconv.ParseInt( fields[21], 10, 64 )
+  return processStat{
+    Name:         content[opening+1 : closing],
+    State:        fields[0],
+    ParentID:     parentID,
+    ProcessGroup: processGroup,
+    CPUTime:      time.Duration( userTicks+systemTicks ) * time.Second / clockTicks,
+    ResidentSize: residentPages * int64( os.Getpagesize() ),
+  }, nil
+}
+
+// readCommandLine reads the arguments of the process separated by spaces; it is empty for a zombie
+// or a kernel thread
+func readCommandLine( pid int ) string {
+  data, err := os.ReadFile( fmt.Sprintf( "/proc/%d/cmdline", pid ) )
+  if err != nil {
+    return ""
+  }
+  return strings.Join( strings.Split( strings.TrimRight( string( data ), "\x00" ), "\x00" ), " " )
+}
+
+// processIDs returns the IDs of every process visible in /proc
+func processIDs() ( []int, error ) {
+  entries, err := os.ReadDir( "/proc" )
+  if err != nil {
+    return nil, err
+  }
+
+  var pids []int
+  for _, entry := range entries {
+    if pid, err := strconv.Atoi( entry.Name() ); err == nil {
+      pids = append( pids, pid )
+    }
+  }
+  return pids, nil
+}
+
+// processGroupReadingTerminal reports whether a process of the process group is blocked reading the
+// terminal of the shell
+func processGroupReadingTerminal( processGroup int, shellPid int ) bool {
+  terminal, err := os.Readlink( fmt.Sprintf( "/proc/%d/fd/0", shellPid ) )
+  if err != nil {
+    return false
+  }
+
+  pids, err := processIDs()
+  if err != nil {
+    return false
+  }
+  for _, pid := range pids {
+    stat, err := readProcessStat( pid )
+    if err != nil || stat.ProcessGroup != processGroup || stat.State != "S" {
+      continue
+    }
+    if readingTerminal( pid, terminal ) {
+      return true
+    }
+  }
+  return false
+}
+
+// readingTerminal reports whether the process is blocked in a read of the terminal; the current
+// system call is used where it is visible and the wait channel otherwise
+func readingTerminal( pid int, terminal string ) bool {
+  data, err := os.ReadFile( fmt.Sprintf( "/proc/%d/syscall", pid ) )
+  if err == nil {
+    fields := strings.Fields( string( data ) )
+    if len( fields ) < 2 || fields[0] != strconv.Itoa( syscall.SYS_READ ) {
+      return false
+    }
+    fd, err := strconv.ParseInt( fields[1], 0, 64 )
+    if err != nil {
+      return false
+    }
+    target, err := os.Readlink( fmt.Sprintf( "/proc/%d/fd/%d", pid, fd ) )
+    return err == nil && target == terminal
+  }
+
+  wchan, err := os.ReadFile( fmt.Sprintf( "/proc/%d/wchan", pid ) )
+  return err == nil && strings.Contains( string( wchan ), "tty_read" )
+}
+
+// waitingForTerminal reports whether the process is blocked waiting for input from the terminal,
+// either reading it or selecting on its standard input alone, which is how readline waits at the
+// prompt of bash
+func waitingForTerminal( pid int, terminal string ) bool {
+  if readingTerminal( pid, terminal ) {
+    return true
+  }
+
+  data, err := os.ReadFile( fmt.Sprintf( "/proc/%d/syscall", pid ) )
+  if err != nil {
+    wchan, err := os.ReadFile( fmt.Sprintf( "/proc/%d/wchan", pid ) )
+    return err == nil && strings.Contains( string( wchan ), "poll_schedule_timeout" )
+  }
+  fields := strings.Fields( string( data ) )
+  if len( fields ) < 2 || fields[0] != strconv.Itoa( syscall.SYS_PSELECT6 ) || fields[1] != "0x1" {
+    return false
+  }
+  target, err := os.Readlink( fmt.Sprintf( "/proc/%d/fd/0", pid ) )
+  return err == nil && target == terminal
+}
@@ -0,0 +1,312 @@
+package shell
+
+import (
+  "errors"
+  "fmt"
+  "os"
+  "os/exec"
+  "regexp"
+  "sort"
+  "sync"
+  "syscall"
+  "time"
+)
+
+// ErrProcessNotFound is returned when no background process has the requested name
+var ErrProcessNotFound = fmt.Errorf( "The process does not exist." )
+
+// ErrProcessExists is returned when a background process with the requested name is still running
+var ErrProcessExists = fmt.Errorf( "A process with this name is already running." )
+
+// ErrInvalidProcessName is returned when a background process name cannot be used
+var ErrInvalidProcessName = fmt.Errorf( "The process name must start with a letter or digit and contain only letters, digits, dots, dashes and underscores." )
+
+// ErrShellNotRunning is returned when the shell has not been started or has failed
+var ErrShellNotRunning = fmt.Errorf( "The shell is not running." )
+
+// processNamePattern matches the names a background process can be given
+var processNamePattern = regexp.MustCompile( `^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$` )
+
+// ProcessStatus is the status of a background process
+type ProcessStatus string
+
+const (
+  ProcessRunning ProcessStatus = "running" // process still running
+  ProcessExited  ProcessStatus = "exited"  // process exited or was stopped
+)
+
+// Process is a snapshot of a background process; the end time and exit code are only set once it
+// has exited
+type Process struct {
+  Name     string
+  Command  string
+  PID      int
+  Status   ProcessStatus
+  Started  time.Time
+  Ended    time.Time
+  ExitCode int
+}
+
+// backgroundProcess is a long-running process started next to the shell in its own process group
+type backgroundProcess struct {
+  name     string
+  command  string
+  cmd      *exec.Cmd
+  output   *tailBuffer
+  started  time.Time
+  ended    time.Time
+  exitCode int
+  done     chan struct{}
+}
+
+// StartProcess starts a named background process running the command with the shell; it runs in
+// the session's working directory with the variables the session reported, and the options apply
+// on top of them
+func ( shell *Shell ) StartProcess( name string, command string, options Options ) ( Process, error ) {
+  shell.mu.Lock()
+  defer shell.mu.Unlock()
+
+  if shell.cmd == nil || shell.state == StateAvailable || shell.state == StateUnrecoverable {
+    return Process{}, ErrShellNotRunning
+  }
+  if !processNamePattern.MatchString( name ) {
+    return Process{}, ErrInvalidProcessName
+  }
+  if existing, ok := shell.processes[name]; ok && existing.ended.IsZero() {
+    return Process{}, ErrProcessExists
+  }
+  if err := options.validate(); err != nil {
+    return Process{}, err
+  }
+
+  cmd := exec.Command( shell.shellCommand, "-c", command )
+  cmd.Env = os.Environ()
+  shell.credential.apply( cmd )
+  for variable, value := range shell.variables {
+    cmd.Env = append( cmd.Env, variable+"="+value )
+  }
+  for variable, value := range options.Environment {
+    cmd.Env = append( cmd.Env, variable+"="+value )
+  }
+  cmd.Dir = shell.directory
+  if options.WorkingDirectory != "" {
+    cmd.Dir = options.WorkingDirectory
+  }
+
+  // the process group lets the process be stopped with everything it started and the process
+  // shares the limits of the shell
+  if cmd.SysProcAttr == nil {
+    cmd.SysProcAttr = &syscall.SysProcAttr{}
+  }
+  cmd.SysProcAttr.Setpgid = true
+  if shell.cgroup != nil {
+    cmd.SysProcAttr.UseCgroupFD = true
+    cmd.SysProcAttr.CgroupFD = shell.cgroup.fd()
+  }
+  output := &tailBuffer{ maximum: shell.outputLimits.MaximumBuffered }
+  cmd.Stdout = output
+  cmd.Stderr = output
+  // a child that keeps the output open after the process exits does not hold up the wait
+  cmd.WaitDelay = time.Second
+
+  if err := shell.rlimits.start( cmd, cmd.Start ); err != nil {
+    if cmd.Process != nil {
+      cmd.Wait()
+      return Process{}, fmt.Errorf( "The process resource limits could not be applied: %w", err )
+    }
+    return Process{}, fmt.Errorf( "The process could not be started: %w", err )
+  }
+
+  process := &backgroundProcess{
+    name:    name,
+    command: command,
+    cmd:     cmd,
+    output:  output,
+    started: time.Now(),
+    done:    make( chan struct{} ),
+  }
+  if shell.processes == nil {
+    shell.processes = make( map[string]*backgroundProcess )
+  }
+  shell.processes[name] = process
+  shell.logger.Info( "Shell | StartProcess | The background process has started.",
+                     "name", name, "pid", cmd.Process.Pid )
+
+  go shell.waitProcess( process )
+  return process.snapshot(), nil
+}
+
+// waitProcess records the exit of a background process
+func ( shell *Shell ) waitProcess( process *backgroundProcess ) {
+  process.cmd.Wait()
+
+  shell.mu.Lock()
+  process.ended = time.Now()
+  process.exitCode = exitCode( process.cmd.ProcessState )
+  shell.mu.Unlock()
+
+  shell.logger.Info( "Shell | WaitProcess | The background process has exited.",
+                     "name", process.name, "exit_code", process.exitCode )
+  close( process.done )
+}
+
+// Processes returns the background processes, oldest first
+func ( shell *Shell ) Processes() []Process {
+  shell.mu.Lock()
+  defer shell.mu.Unlock()
+
+  processes := make( []Process, 0, len( shell.processes ) )
+  for _, process := range shell.processes {
+    processes = append( processes, process.snapshot() )
+  }
+  sort.Slice( processes, func( i, j int ) bool {
+    return processes[i].Started.Before( processes[j].Started )
+  } )
+  return processes
+}
+
+// Process returns the background process with the name
+func ( shell *Shell ) Process( name string ) ( Process, error ) {
+  shell.mu.Lock()
+  defer shell.mu.Unlock()
+
+  process, ok := shell.processes[name]
+  if !ok {
+    return Process{}, ErrProcessNotFound
+  }
+  return process.snapshot(), nil
+}
+
+// ProcessOutput returns the output the background process wrote to stdout and stderr; only the
+// last bytes up to the buffered output limit are kept
+func ( shell *Shell ) ProcessOutput( name string ) ( []byte, error ) {
+  shell.mu.Lock()
+  process, ok := shell.processes[name]
+  shell.mu.Unlock()
+
+  if !ok {
+    return nil, ErrProcessNotFound
+  }
+  return process.output.bytes(), nil
+}
+
+// StopProcess stops the background process and removes it; its process group receives SIGTERM and,
+// if it is still running after the kill grace period, SIGKILL
+func ( shell *Shell ) StopProcess( name string ) ( Process, error ) {
+  shell.mu.Lock()
+  process, ok := shell.processes[name]
+  if ok {
+    delete( shell.processes, name )
+  }
+  shell.mu.Unlock()
+
+  if !ok {
+    return Process{}, ErrProcessNotFound
+  }
+  process.stop( shell.killGracePeriod )
+
+  shell.mu.Lock()
+  defer shell.mu.Unlock()
+  return process.snapshot(), nil
+}
+
+// stopProcesses stops every background process
+func ( shell *Shell ) stopProcesses() {
+  shell.mu.Lock()
+  processes := shell.processes
+  shell.processes = nil
+  shell.mu.Unlock()
+
+  var stopping sync.WaitGroup
+  for _, process := range processes {
+    stopping.Add( 1 )
+    go func( process *backgroundProcess ) {
+      defer stopping.Done()
+      process.stop( shell.killGracePeriod )
+    }( process )
+  }
+  stopping.Wait()
+}
+
+// stop signals the process group and waits for the process to exit; a process that already exited
+// was reaped, so its process group ID may belong to an unrelated process by now and is not signalled
+func ( process *backgroundProcess ) stop( gracePeriod time.Duration ) {
+  select {
+  case <-process.done:
+    return
+  default:
+  }
+
+  pid := process.cmd.Process.Pid
+  if err := syscall.Kill( -pid, syscall.SIGTERM ); err != nil && !errors.Is( err, syscall.ESRCH ) {
+    process.cmd.Process.Signal( syscall.SIGTERM )
+  }
+
+  select {
+  case <-process.done:
+  case <-time.After( gracePeriod ):
+    syscall.Kill( -pid, syscall.SIGKILL )
+    <-process.done
+  }
+}
+
+// snapshot returns the public view of the process; the caller holds the lock
+func ( process *backgroundProcess ) snapshot() Process {
+  snapshot := Process{
+    Name:    process.name,
+    Command: process.command,
+    PID:     process.cmd.Process.Pid,
+    Status:  ProcessRunning,
+    Started: process.started,
+  }
+  if !process.ended.IsZero() {
+    snapshot.Status = ProcessExited
+    snapshot.Ended = process.ended
+    snapshot.ExitCode = process.exitCode
+  }
+  return snapshot
+}
+
+// exitCode returns the exit code of a process the way the shell reports it; a process killed by a
+// signal exits with 128 plus the signal number
+func exitCode( state *os.ProcessState ) int {
+  if state == nil {
+    return -1
+  }
+  if status, ok := state.Sys().( syscall.WaitStatus ); ok && status.Signaled() {
+    return 128 + int( status.Signal() )
+  }
+  return state.ExitCode()
+}
+
+// tailBuffer keeps the last maximum bytes written to it; a maximum of zero or less keeps everything
+type tailBuffer struct {
+  mu      sync.Mutex
+  maximum int
+  data    []byte
+}
+
+// Write appends the data, dropping the oldest bytes once twice the maximum is buffered so the
+// buffer is not copied on every write
+func ( buffer *tailBuffer ) Write( data []byte ) ( int, error ) {
+  buffer.mu.Lock()
+  defer buffer.mu.Unlock()
+
+  buffer.data = append( buffer.data, data... )
+  if buffer.maximum > 0 && len( buffer.data ) > 2*buffer.maximum {
+    buffer.data = append( buffer.data[:0], buffer.data[len( buffer.data )-buffer.maximum:]... )
+  }
+  return len( data ), nil
+}
+
+// bytes returns a copy of the last maximum bytes written
+func ( buffer *tailBuffer ) bytes() []byte {
+  buffer.mu.Lock()
+  defer buffer.mu.Unlock()
+
+  data := buffer.data
+  if buffer.maximum > 0 && len( data ) > buffer.maximum {
+    data = data[len( data )-buffer.maximum:]
+  }
+  return append( []byte{}, data... )
+}
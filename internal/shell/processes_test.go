@@ -0,0 +1,146 @@
+package shell
+
+import (
+  "errors"
+  "strings"
+  "syscall"
+  "testing"
+  "time"
+)
+
+// waitProcessExit waits until the background process has exited
+func waitProcessExit( t *testing.T, shell *Shell, name string ) Process {
+  t.Helper()
+  for attempt := 0; attempt < 100; attempt++ {
+    process, err := shell.Process( name )
+    if err != nil {
+      t.Fatalf( "The process should exist: %v", err )
+    }
+    if process.Status == ProcessExited {
+      return process
+    }
+    time.Sleep( 50 * time.Millisecond )
+  }
+  t.Fatalf( "The process %s never exited.", name )
+  return Process{}
+}
+
+// processGroupAlive reports whether a process of the group is still running; a killed process can
+// stay a zombie until its new parent reaps it
+func processGroupAlive( processGroup int ) bool {
+  pids, _ := processIDs()
+  for _, pid := range pids {
+    if stat, err := readProcessStat( pid ); err == nil && stat.ProcessGroup == processGroup && stat.State != "Z" {
+      return true
+    }
+  }
+  return false
+}
+
+func TestShellProcesses( t *testing.T ) {
+  shell := newTestShell( t )
+  defer shell.Unlock()
+
+  if _, err := shell.StartProcess( "early", "true", Options{} ); !errors.Is( err, ErrShellNotRunning ) {
+    t.Errorf( "A process should not start before the shell: %v", err )
+  }
+  if err := shell.Start(); err != nil {
+    t.Fatalf( "The shell failed to start: %v", err )
+  }
+
+  // the process starts in the session's working directory
+  if _, err := shell.Execute( "cd /tmp", 30*time.Second, Options{} ); err != nil {
+    t.Fatalf( "The command failed: %v", err )
+  }
+  options := Options{ Environment: map[string]string{ "PROCESS_TEST": "set" } }
+  if _, err := shell.StartProcess( "once", "echo $PROCESS_TEST; pwd; exit 3", options ); err != nil {
+    t.Fatalf( "The process failed to start: %v", err )
+  }
+  process := waitProcessExit( t, shell, "once" )
+  if process.ExitCode != 3 {
+    t.Errorf( "The process should have exit code 3, but got %d.", process.ExitCode )
+  }
+  output, err := shell.ProcessOutput( "once" )
+  if err != nil || string( output ) != "set\n/tmp\n" {
+    t.Errorf( "The output should be 'set\\n/tmp\\n', but got '%s' ( %v ).", output, err )
+  }
+
+  // a running process keeps its name until it is stopped
+  server, err := shell.StartProcess( "server", "echo listening; sleep 30", Options{} )
+  if err != nil {
+    t.Fatalf( "The process failed to start: %v", err )
+  }
+  if server.Status != ProcessRunning || server.PID == 0 {
+    t.Errorf( "The process should be running, but got %+v.", server )
+  }
+  if _, err := shell.StartProcess( "server", "true", Options{} ); !errors.Is( err, ErrProcessExists ) {
+    t.Errorf( "A second process with the same name should be rejected: %v", err )
+  }
+  if _, err := shell.StartProcess( "../bad name", "true", Options{} ); !errors.Is( err, ErrInvalidProcessName ) {
+    t.Errorf( "An invalid name should be rejected: %v", err )
+  }
+
+  processes := shell.Processes()
+  if len( processes ) != 2 || processes[0].Name != "once" || processes[1].Name != "server" {
+    t.Errorf( "The processes should be listed oldest first, but got %+v.", processes )
+  }
+
+  // stopping terminates the process group and removes the process
+  stopped, err := shell.StopProcess( "server" )
+  if err != nil {
+    t.Fatalf( "The process failed to stop: %v", err )
+  }
+  if stopped.Status != ProcessExited || stopped.ExitCode != 128+int( syscall.SIGTERM ) {
+    t.Errorf( "The process should have been terminated, but got %+v.", stopped )
+  }
+  if _, err := shell.Process( "server" ); !errors.Is( err, ErrProcessNotFound ) {
+    t.Errorf( "The stopped process should be removed: %v", err )
+  }
+}
+
+func TestShellProcessesReapedOnUnlock( t *testing.T ) {
+  shell := newTestShell( t )
+  shell.killGracePeriod = 500 * time.Millisecond
+
+  if err := shell.Start(); err != nil {
+    t.Fatalf( "The shell failed to start: %v", err )
+  }
+
+  // a process that ignores SIGTERM is killed once the grace period ends
+  stubborn, err := shell.StartProcess( "stubborn", "trap '' TERM; echo ready; while true; do sleep 1; done", Options{} )
+  if err != nil {
+    t.Fatalf( "The process failed to start: %v", err )
+  }
+  for attempt := 0; attempt < 100; attempt++ {
+    if output, _ := shell.ProcessOutput( "stubborn" ); strings.Contains( string( output ), "ready" ) {
+      break
+    }
+    time.Sleep( 50 * time.Millisecond )
+  }
+
+  if err := shell.Unlock(); err != nil {
+    t.Fatalf( "The shell failed to unlock: %v", err )
+  }
+  if processGroupAlive( stubborn.PID ) {
+    t.Error( "The process group should be gone after unlock." )
+  }
+  if processes := shell.Processes(); len( processes ) != 0 {
+    t.Errorf( "No process should be left after unlock, but got %+v.", processes )
+  }
+}
+
+func TestTailBuffer( t *testing.T ) {
+  buffer := &tailBuffer{ maximum: 4 }
+  for _, chunk := range []string{ "ab", "cdef", "ghijk" } {
+    buffer.Write( []byte( chunk ) )
+  }
+  if output := string( buffer.bytes() ); output != "hijk" {
+    t.Errorf( "The buffer should keep the last 4 bytes, but got '%s'.", output )
+  }
+
+  unlimited := &tailBuffer{}
+  unlimited.Write( []byte( "abcdef" ) )
+  if output := string( unlimited.bytes() ); output != "abcdef" {
+    t.Errorf( "The buffer without a maximum should keep everything, but got '%s'.", output )
+  }
+}
@@ -0,0 +1,75 @@
+package shell
+
+import (
+  "fmt"
+)
+
+// ErrCancelled is returned when a queued command is removed from the queue before it starts
+var ErrCancelled = fmt.Errorf( "The command was cancelled before it started." )
+
+// queuedCommand is a command submitted to the shell, waiting in the queue until the running
+// command completes
+type queuedCommand struct {
+  id      uint64
+  command string
+  options Options
+  done    chan commandOutcome
+}
+
+// commandOutcome is what the caller waiting on a command receives once it ends
+type commandOutcome struct {
+  result *Result
+  err    error
+}
+
+// queued reports whether the command with the ID is waiting in the queue; the caller holds the lock
+func ( shell *Shell ) queued( id uint64 ) bool {
+  for _, next := range shell.queue {
+    if next.id == id {
+      return true
+    }
+  }
+  return false
+}
+
+// runQueued starts the next queued command once the shell is ready; the caller holds the lock
+func ( shell *Shell ) runQueued() {
+  for shell.state == StateLocked && len( shell.queue ) > 0 {
+    next := shell.queue[0]
+    shell.queue = shell.queue[1:]
+
+    shell.logger.Debug( "Shell | RunQueued | Starting the next queued command.", "id", next.id )
+    if err := shell.run( next ); err != nil {
+      next.done <- commandOutcome{ err: err }
+      if shell.state != StateLocked {
+        shell.failQueue( err )
+      }
+    }
+  }
+}
+
+// cancelQueued removes the command with the ID from the queue and reports whether it was queued;
+// the caller holds the lock
+func ( shell *Shell ) cancelQueued( id uint64 ) bool {
+  for index, next := range shell.queue {
+    if next.id != id {
+      continue
+    }
+    shell.logger.Debug( "Shell | CancelQueued | The queued command was cancelled.", "id", id )
+    shell.queue = append( shell.queue[:index:index], shell.queue[index+1:]... )
+    shell.history.finish( id, CommandCancelled, nil )
+    next.done <- commandOutcome{ err: ErrCancelled }
+    shell.notifyOutput()
+    return true
+  }
+  return false
+}
+
+// failQueue ends every queued command with the error; the caller holds the lock
+func ( shell *Shell ) failQueue( err error ) {
+  for _, next := range shell.queue {
+    shell.history.finish( next.id, CommandFailed, nil )
+    next.done <- commandOutcome{ err: err }
+  }
+  shell.queue = nil
+}
@@ -0,0 +1,161 @@
+package shell
+
+import (
+  "errors"
+  "fmt"
+  "io"
+  "os"
+  "os/exec"
+  "slices"
+  "strconv"
+  "strings"
+  "syscall"
+
+  "golang.org/x/sys/unix"
+)
+
+// rlimitHelper is the name the server runs itself under to set the limits on its own process and
+// then exec the limited command
+const rlimitHelper = "shelld-rlimits"
+
+// rlimitResources are the limits in the order they are set, by the name they are passed to the helper
+// under; the process limit is set last so the helper itself is not held back by it
+var rlimitResources = []struct {
+  key      string
+  name     string
+  resource int
+}{
+  { "NOFILE", "open files", unix.RLIMIT_NOFILE },
+  { "FSIZE", "file size", unix.RLIMIT_FSIZE },
+  { "CORE", "core size", unix.RLIMIT_CORE },
+  { "CPU", "CPU time", unix.RLIMIT_CPU },
+  { "NPROC", "processes", unix.RLIMIT_NPROC },
+}
+
+// the helper runs before anything else of the server, whichever binary the package is part of
+func init() {
+  if len( os.Args ) > 1 && os.Args[0] == rlimitHelper {
+    runRlimitHelper( os.Args[1:] )
+  }
+}
+
+// Rlimits are resource limits set on the shell and the background processes and inherited by
+// everything they start; a nil limit is inherited from the server
+type Rlimits struct {
+  OpenFiles *int64 // RLIMIT_NOFILE
+  FileSize  *int64 // RLIMIT_FSIZE in bytes
+  CoreSize  *int64 // RLIMIT_CORE in bytes
+  CPUTime   *int64 // RLIMIT_CPU in seconds
+  Processes *int64 // RLIMIT_NPROC, counted per user
+}
+
+// set reports whether any limit is set
+func ( limits Rlimits ) set() bool {
+  return limits.OpenFiles != nil || limits.FileSize != nil || limits.CoreSize != nil ||
+         limits.CPUTime != nil || limits.Processes != nil
+}
+
+// arguments returns the limits that are set as the arguments of the helper
+func ( limits Rlimits ) arguments() []string {
+  values := map[string]*int64{
+    "NOFILE": limits.OpenFiles,
+    "FSIZE":  limits.FileSize,
+    "CORE":   limits.CoreSize,
+    "CPU":    limits.CPUTime,
+    "NPROC":  limits.Processes,
+  }
+
+  var arguments []string
+  for _, limit := range rlimitResources {
+    if value := values[limit.key]; value != nil {
+      arguments = append( arguments, fmt.Sprintf( "%s=%d", limit.key, *value ) )
+    }
+  }
+  return arguments
+}
+
+// start starts the command with the start function and applies the limits before the command runs;
+// the command is started through the helper, which runs with the credentials of the command, sets the
+// limits and then replaces itself with the command. A command that could not be limited has exited
+// and still has to be waited for
+func ( limits Rlimits ) start( cmd *exec.Cmd, start func() error ) error {
+  if !limits.set() {
+    return start()
+  }
+
+  // the helper reports an error on the pipe, which is closed without a word once the exec succeeds
+  reader, writer, err := os.Pipe()
+  if err != nil {
+    return err
+  }
+  defer reader.Close()
+
+  arguments := []string{ rlimitHelper, strconv.Itoa( 3 + len( cmd.ExtraFiles ) ) }
+  arguments = append( arguments, limits.arguments()... )
+  arguments = append( append( arguments, "--", cmd.Path ), cmd.Args... )
+  cmd.Path = "/proc/self/exe"
+  cmd.Args = arguments
+  cmd.ExtraFiles = append( cmd.ExtraFiles, writer )
+
+  err = start()
+  writer.Close()
+  if err != nil {
+    return err
+  }
+
+  report, err := io.ReadAll( reader )
+  if err != nil {
+    syscall.Kill( cmd.Process.Pid, syscall.SIGKILL )
+    return err
+  }
+  if len( report ) > 0 {
+    return errors.New( string( report ) )
+  }
+  return nil
+}
+
+// runRlimitHelper sets the limits given as arguments on its own process and replaces itself with the
+// command that follows them; the first argument is the descriptor errors are reported on. It never
+// returns
+func runRlimitHelper( arguments []string ) {
+  fd, _ := strconv.Atoi( arguments[0] )
+  err := fmt.Errorf( "The limits helper was started without a command." )
+  separator := slices.Index( arguments, "--" )
+  if separator > 0 && separator+2 < len( arguments ) {
+    err = applyRlimits( arguments[1:separator] )
+    if err == nil {
+      syscall.CloseOnExec( fd )
+      err = syscall.Exec( arguments[separator+1], arguments[separator+2:], os.Environ() )
+      err = fmt.Errorf( "The command could not be run: %w", err )
+    }
+  }
+
+  fmt.Fprint( os.NewFile( uintptr( fd ), "report" ), err.Error() )
+  os.Exit( 127 )
+}
+
+// applyRlimits sets the limits given as KEY=value arguments on the own process as both the soft and
+// the hard limit, so neither the process nor anything it starts can raise them again without
+// privileges
+func applyRlimits( arguments []string ) error {
+  values := make( map[string]string, len( arguments ) )
+  for _, argument := range arguments {
+    key, value, _ := strings.Cut( argument, "=" )
+    values[key] = value
+  }
+
+  for _, limit := range rlimitResources {
+    text, ok := values[limit.key]
+    if !ok {
+      continue
+    }
+    value, err := strconv.ParseUint( text, 10, 64 )
+    if err != nil {
+      return fmt.Errorf( "The %s limit '%s' is invalid.", limit.name, text )
+    }
+    if err := unix.Prlimit( 0, limit.resource, &unix.Rlimit{ Cur: value, Max: value }, nil ); err != nil {
+      return fmt.Errorf( "The %s limit could not be set to %d: %w", limit.name, value, err )
+    }
+  }
+  return nil
+}
@@ -0,0 +1,62 @@
+package shell
+
+import (
+  "os/exec"
+  "strings"
+  "testing"
+  "time"
+)
+
+func TestShellRlimits( t *testing.T ) {
+  openFiles, fileSize, coreSize := int64( 64 ), int64( 1 << 20 ), int64( 0 )
+  shell := newTestShell( t )
+  shell.rlimits = Rlimits{ OpenFiles: &openFiles, FileSize: &fileSize, CoreSize: &coreSize }
+  defer shell.Unlock()
+
+  if err := shell.Start(); err != nil {
+    t.Fatalf( "The shell failed to start: %v", err )
+  }
+
+  // bash reports the file size in blocks of 1024 bytes and the hard limit matches the soft one
+  result, err := shell.Execute( "ulimit -n; ulimit -f; ulimit -c; ulimit -Hn", 30*time.Second, Options{} )
+  if err != nil || result.Output != "64\n1024\n0\n64" {
+    t.Errorf( "The limits should be '64\\n1024\\n0\\n64', but got '%s' ( %v ).", result.Output, err )
+  }
+
+  // a background process gets the same limits
+  if _, err := shell.StartProcess( "limited", "ulimit -n", Options{} ); err != nil {
+    t.Fatalf( "The process failed to start: %v", err )
+  }
+  waitProcessExit( t, shell, "limited" )
+  if output, _ := shell.ProcessOutput( "limited" ); string( output ) != "64\n" {
+    t.Errorf( "The process should have 64 open files, but got '%s'.", output )
+  }
+}
+
+func TestShellRlimitsRejected( t *testing.T ) {
+  // no process can have more open files than fs.nr_open, not even root
+  openFiles := int64( 1 << 40 )
+  shell := newTestShell( t )
+  shell.rlimits = Rlimits{ OpenFiles: &openFiles }
+  defer shell.Unlock()
+
+  if err := shell.Start(); err == nil {
+    t.Fatal( "The shell should not start when its limits cannot be set." )
+  }
+  if shell.State() != StateUnrecoverable {
+    t.Errorf( "The shell should be unrecoverable, but got %s.", shell.State() )
+  }
+}
+
+func TestRlimitsCommandFails( t *testing.T ) {
+  // the helper reports a command it cannot run instead of running it without its limits
+  openFiles := int64( 64 )
+  cmd := exec.Command( "/nonexistent/shell" )
+  err := Rlimits{ OpenFiles: &openFiles }.start( cmd, cmd.Start )
+  if err == nil || !strings.Contains( err.Error(), "no such file or directory" ) {
+    t.Errorf( "A missing command should fail to start, but got %v.", err )
+  }
+  if cmd.Process != nil {
+    cmd.Wait()
+  }
+}
@@ -2,16 +2,20 @@ package shell
 
 import (
   "bytes"
+  "context"
   "encoding/base64"
   "fmt"
   "io"
   "log/slog"
   "os"
   "os/exec"
+  "regexp"
+  "strconv"
   "strings"
   "sync"
   "syscall"
   "time"
+  "unsafe"
 
   "github.com/creack/pty"
 )
@@ -23,12 +27,72 @@ const (
   StateAvailable     State = "available"     // initial state, no shell running
   StateLocked        State = "locked"        // shell running, waiting for commands
   StateExecuting     State = "executing"    // shell executing a command
+  StateAttached      State = "attached"      // terminal attached to the shell for manual use
   StateUnrecoverable State = "unrecoverable" // shell in error state, needs recycle
 )
 
 // ErrTimeout is returned when a command times out waiting for completion
 var ErrTimeout = fmt.Errorf( "The command timed out waiting for completion." )
 
+// ErrNotExecuting is returned when input is sent while no command is running
+var ErrNotExecuting = fmt.Errorf( "There is no running command to receive input." )
+
+// ErrCommandFinished is returned when a command that is no longer running is killed
+var ErrCommandFinished = fmt.Errorf( "The command is no longer running." )
+
+// ErrShellClosed is returned when the shell is closed before the command completes
+var ErrShellClosed = fmt.Errorf( "The shell was closed." )
+
+// ErrKillFailed is returned when an escalating kill could not stop the command
+var ErrKillFailed = fmt.Errorf( "The command could not be stopped." )
+
+// ErrRestarted is returned for a command that was running when the shell failed and was replaced
+// by a fresh shell
+var ErrRestarted = fmt.Errorf( "The shell failed and was restarted." )
+
+// ErrNotCurrentCommand is returned when the output of a command is requested after another command
+// has started
+var ErrNotCurrentCommand = fmt.Errorf( "The job is no longer the running or last command." )
+
+// ErrNoCommand is returned when there is no running or completed command to follow
+var ErrNoCommand = fmt.Errorf( "There is no command to follow." )
+
+// awaitingInputQuietPeriod is how long a running command has to be silent before it is considered
+// to be waiting for input
+const awaitingInputQuietPeriod = 500 * time.Millisecond
+
+// RestartPolicy controls how the shell is brought back after it fails
+type RestartPolicy struct {
+  Automatic  bool   // replace a shell that failed while running a command instead of leaving it unrecoverable
+  InitScript string // commands run in the shell whenever it starts, including after a restart
+}
+
+// Config is the configuration a shell is created with
+type Config struct {
+  Command          string        // shell binary
+  WorkingDirectory string        // directory the shell starts in, empty for the server's own
+  KillGracePeriod  time.Duration // time between SIGTERM and SIGKILL when a command is stopped
+  OutputLimits     OutputLimits
+  HistorySize      int           // completed commands kept in the history, zero or less disables it
+  QueueDepth       int           // commands waiting for the shell, zero rejects a busy shell
+  RestartPolicy    RestartPolicy
+  StateVariables   []string      // variables reported with the session state
+  Limits           Limits
+  Rlimits          Rlimits
+  Credential       *Credential   // user the shell runs as, nil for the server's own
+}
+
+// Result holds the output and exit code of a completed command
+type Result struct {
+  ID             uint64
+  Output         string
+  Stderr         string
+  SeparateStderr bool // stderr was returned in Stderr instead of in the output
+  ExitCode       int
+  Duration       time.Duration
+  Truncated      bool
+}
+
 // Shell manages a persistent shell session with PTY
 type Shell struct {
   mu                sync.Mutex
@@ -36,29 +100,64 @@ type Shell struct {
   cmd               *exec.Cmd
   ptyFile           *os.File
   outputBuffer      *bytes.Buffer
+  output            *outputStore
+  previousOutput    *outputStore
+  outputLimits      OutputLimits
+  history           *history
+  queue             []queuedCommand
+  queueDepth        int
+  restartPolicy     RestartPolicy
+  restarts          int
+  restartedCommand  uint64
+  processes         map[string]*backgroundProcess
+  stateVariables    []string
+  limits            Limits
+  rlimits           Rlimits
+  credential        *Credential
+  cgroup            *cgroup
+  directory         string
+  variables         map[string]string
+  outputStarted     bool
+  outputClosed      bool
   killGracePeriod   time.Duration
   shellCommand      string
   workingDirectory  string
   logger            *slog.Logger
-  lastOutput        string
-  commandDone       chan error
+  lastResult        *Result
+  commandDone       chan commandOutcome
+  commandID         uint64
+  lastCommandID     uint64
+  outputChanged     chan struct{}
   currentCommand    string
+  commandStarted    time.Time
+  lastOutput        time.Time
+  stderrPath        string
+  exactOutput       bool
+  filter            Filter
   startMarker       string
   endMarker         string
+  endMarkerPattern  *regexp.Regexp
+  terminal          *Terminal
 }
 
 // NewShell creates a new shell manager
-func NewShell( shellCommand string,
-               workingDirectory string,
-               killGracePeriod time.Duration,
-               logger *slog.Logger ) *Shell {
+func NewShell( config Config, logger *slog.Logger ) *Shell {
   return &Shell{
     state:            StateAvailable,
-    killGracePeriod:  killGracePeriod,
-    shellCommand:     shellCommand,
-    workingDirectory: workingDirectory,
+    killGracePeriod:  config.KillGracePeriod,
+    outputLimits:     config.OutputLimits,
+    history:          newHistory( config.HistorySize ),
+    queueDepth:       config.QueueDepth,
+    restartPolicy:    config.RestartPolicy,
+    stateVariables:   config.StateVariables,
+    limits:           config.Limits,
+    rlimits:          config.Rlimits,
+    credential:       config.Credential,
+    shellCommand:     config.Command,
+    workingDirectory: config.WorkingDirectory,
     logger:           logger,
     outputBuffer:     &bytes.Buffer{},
+    outputChanged:    make( chan struct{} ),
   }
 }
 
@@ -80,61 +179,228 @@ func ( shell *Shell ) Start() error {
 
   shell.logger.Info( "Shell | Start | The shell is starting.", "command", shell.shellCommand )
 
+  // the history belongs to the key the shell is started for
+  shell.history.clear()
+  shell.restarts = 0
+  shell.restartedCommand = 0
+
+  // the cgroup is kept across restarts so its counters cover the whole session
+  if shell.limits.enabled() && shell.cgroup == nil {
+    group, err := createCgroup( shell.limits )
+    if err != nil {
+      return fmt.Errorf( "The shell limits could not be applied: %w", err )
+    }
+    shell.cgroup = group
+    shell.logger.Info( "Shell | Start | The shell cgroup was created.", "path", group.path )
+  }
+  return shell.start()
+}
+
+// start launches the shell process and waits until it is ready; the caller holds the lock
+func ( shell *Shell ) start() error {
   cmd := exec.Command( shell.shellCommand )
   cmd.Env = append( os.Environ(), "TERM=xterm-256color", )
 
   if shell.workingDirectory != "" {
     cmd.Dir = shell.workingDirectory
   }
+  shell.credential.apply( cmd )
+  // the shell starts inside its cgroup so nothing it runs escapes the limits
+  if shell.cgroup != nil {
+    if cmd.SysProcAttr == nil {
+      cmd.SysProcAttr = &syscall.SysProcAttr{}
+    }
+    cmd.SysProcAttr.UseCgroupFD = true
+    cmd.SysProcAttr.CgroupFD = shell.cgroup.fd()
+  }
 
-  ptyFile, err := pty.Start( cmd )
-  if err != nil {
+  var ptyFile *os.File
+  err := shell.rlimits.start( cmd, func() error {
+    var err error
+    ptyFile, err = pty.Start( cmd )
+    return err
+  } )
+  // the PTY is open once the shell was started, so a failure after that is a limit that was not set
+  if err != nil && ptyFile == nil {
     shell.state = StateUnrecoverable
     return fmt.Errorf( "The PTY could not be allocated: %w", err )
   }
+  if err != nil {
+    cmd.Wait()
+    ptyFile.Close()
+    shell.state = StateUnrecoverable
+    return fmt.Errorf( "The shell resource limits could not be applied: %w", err )
+  }
 
   shell.cmd = cmd
   shell.ptyFile = ptyFile
   shell.outputBuffer.Reset()
 
+  // programs that open the terminal by name, such as password prompts, need to own it
+  if shell.credential != nil {
+    terminal, err := os.Readlink( fmt.Sprintf( "/proc/%d/fd/0", cmd.Process.Pid ) )
+    if err == nil {
+      err = shell.credential.own( terminal )
+    }
+    if err != nil {
+      shell.logger.Warn( "Shell | Start | The terminal could not be given to the shell user.", "error", err )
+    }
+  }
+
   // verify shell is ready using a marker echo
   readyMarker := fmt.Sprintf( "<<<SHELLD_READY_%d>>>", time.Now().UnixNano() )
   shell.ptyFile.Write( []byte( fmt.Sprintf( "echo '%s'\n", readyMarker ) ) )
 
-  if err := shell.waitForOutput( readyMarker, 30*time.Second ); err != nil {
+  // wait for the marker output rather than the terminal echo of the typed command; the echo is
+  // followed by the closing quote while the output is followed by a line break
+  if err := shell.waitForOutput( readyMarker+"\r\n", 30*time.Second ); err != nil {
     shell.cleanup()
     shell.state = StateUnrecoverable
     return fmt.Errorf( "The shell failed to initialize: %w", err )
   }
 
+  // the init script runs in the shell itself so the variables and directory it sets are kept
+  if shell.restartPolicy.InitScript != "" {
+    initMarker := fmt.Sprintf( "<<<SHELLD_INIT_%d>>>", time.Now().UnixNano() )
+    encodedScript := base64.StdEncoding.EncodeToString( []byte( shell.restartPolicy.InitScript ) )
+    shell.ptyFile.Write( []byte( fmt.Sprintf( "eval \"$(echo '%s'|base64 -d)\";echo '%s'\n",
+                                              encodedScript, initMarker ) ) )
+    if err := shell.waitForOutput( initMarker+"\r\n", 30*time.Second ); err != nil {
+      shell.cleanup()
+      shell.state = StateUnrecoverable
+      return fmt.Errorf( "The init script did not complete: %w", err )
+    }
+  }
+
+  // the session is reported once before the first command and then after every command
+  reportMarker := fmt.Sprintf( "<<<SHELLD_REPORT_%d>>>", time.Now().UnixNano() )
+  shell.outputBuffer.Reset()
+  shell.ptyFile.Write( []byte( fmt.Sprintf( "printf '%%s\\n' \"$(%s)\";echo '%s'\n",
+                                            shell.reportCommand(), reportMarker ) ) )
+  if err := shell.waitForOutput( reportMarker+"\r\n", 30*time.Second ); err != nil {
+    shell.cleanup()
+    shell.state = StateUnrecoverable
+    return fmt.Errorf( "The shell failed to report its state: %w", err )
+  }
+  reportPattern := regexp.MustCompile( `([A-Za-z0-9+/=]*)\r\n` + regexp.QuoteMeta( reportMarker ) + `\r\n` )
+  if match := reportPattern.FindSubmatch( shell.outputBuffer.Bytes() ); match != nil {
+    shell.readReport( match[1] )
+  }
+
   shell.outputBuffer.Reset()
   shell.state = StateLocked
   shell.logger.Info( "Shell | Start | The shell is ready." )
   return nil
 }
 
-// Execute runs a command in the shell and returns its output
-func ( shell *Shell ) Execute( command string, timeout time.Duration ) ( string, error ) {
+// Execute runs a command in the shell and returns its output and exit code; a command submitted
+// while another is running waits in the queue and the timeout includes the time it waits
+func ( shell *Shell ) Execute( command string, timeout time.Duration, options Options ) ( Result, error ) {
+  shell.logger.Debug( "Shell | Run | Executing command.", "command", command, "timeout", timeout )
+
+  id, commandDone, err := shell.begin( command, options )
+  if err != nil {
+    return Result{}, err
+  }
+
+  // wait for completion or timeout; a failed shell has already been marked unrecoverable by the
+  // reader
+  select {
+  case outcome := <-commandDone:
+    if outcome.err != nil {
+      return Result{ ID: id }, outcome.err
+    }
+    return *outcome.result, nil
+
+  case <-time.After( timeout ):
+    // timeout - shell stays busy, reader continues in background; the ID lets the caller find the
+    // result later
+    return Result{ ID: id }, ErrTimeout
+  }
+}
+
+// Submit starts a command in the shell without waiting for it to complete and returns its ID; use
+// FollowCommand to receive its output and result or HistoryEntry to look it up later
+func ( shell *Shell ) Submit( command string, options Options ) ( uint64, error ) {
+  shell.logger.Debug( "Shell | Submit | Submitting command.", "command", command )
+
+  id, _, err := shell.begin( command, options )
+  return id, err
+}
+
+// begin starts the command, or queues it if another command is running and the queue has room;
+// the returned channel receives the outcome once the command has completed and the command is
+// identified by the returned ID
+func ( shell *Shell ) begin( command string, options Options ) ( uint64, chan commandOutcome, error ) {
+  if err := options.validate(); err != nil {
+    return 0, nil, err
+  }
+
   shell.mu.Lock()
+  defer shell.mu.Unlock()
 
-  if shell.state != StateLocked {
-    shell.mu.Unlock()
-    return "", fmt.Errorf( "The shell is not ready ( state: %s ).", shell.state )
+  queueing := shell.state == StateExecuting && len( shell.queue ) < shell.queueDepth
+  if shell.state != StateLocked && !queueing {
+    return 0, nil, fmt.Errorf( "The shell is not ready ( state: %s ).", shell.state )
   }
 
+  shell.lastCommandID++
+  next := queuedCommand{
+    id:      shell.lastCommandID,
+    command: command,
+    options: options,
+    done:    make( chan commandOutcome, 1 ),
+  }
+  shell.history.add( next.id, command )
+
+  if queueing {
+    shell.logger.Debug( "Shell | Begin | The command was queued.", "id", next.id, "position", len( shell.queue )+1 )
+    shell.queue = append( shell.queue, next )
+    return next.id, next.done, nil
+  }
+
+  if err := shell.run( next ); err != nil {
+    return 0, nil, err
+  }
+  return next.id, next.done, nil
+}
+
+// run writes the wrapped command to the shell and starts the background reader; the caller holds
+// the lock and the shell is ready
+func ( shell *Shell ) run( next queuedCommand ) error {
+  command := next.command
+  options := next.options
+
   shell.state = StateExecuting
   shell.outputBuffer.Reset()
-  shell.lastOutput = ""
+  // the output of the previous command stays readable for its followers until the next command
+  // starts
+  if shell.previousOutput != nil {
+    shell.previousOutput.close()
+  }
+  shell.previousOutput = shell.output
+  shell.output = newOutputStore( shell.outputLimits.MaximumBuffered )
+  shell.outputStarted = false
+  shell.outputClosed = false
+  shell.lastResult = nil
   shell.currentCommand = command
-  shell.commandDone = make( chan error, 1 )
+  shell.exactOutput = options.ExactOutput
+  shell.filter = options.Filter
+  shell.commandStarted = time.Now()
+  shell.lastOutput = shell.commandStarted
+  shell.commandDone = next.done
+  shell.commandID = next.id
+  shell.history.start( next.id, shell.commandStarted )
 
   // generate unique start and end markers for this command
   // this eliminates reliance on prompt detection which has timing issues
   markerID := time.Now().UnixNano()
   shell.startMarker = fmt.Sprintf( "<<<SHELLD_START_%d>>>", markerID )
   shell.endMarker = fmt.Sprintf( "<<<SHELLD_END_%d>>>", markerID )
-
-  shell.logger.Debug( "Shell | Run | Executing command.", "command", command, "timeout", timeout )
+  // the end marker output is at the start of a line and followed by the exit code and the session
+  // report; this distinguishes it from the end marker appearing in the command echo
+  shell.endMarkerPattern = regexp.MustCompile( "\n" + regexp.QuoteMeta( shell.endMarker ) +
+                                               `(\d+):([A-Za-z0-9+/=]*)\r\n` )
 
   // the command is wrapped with start and end markers; the output between these markers is the actual
   // command output that is returned to the caller
@@ -142,49 +408,262 @@ func ( shell *Shell ) Execute( command string, timeout time.Duration ) ( string,
   // the command is base64 encoded to handle heredocs and other multiline constructs that require
   // newlines ( can't just replace with semicolons )
 
-  // the extra echo before end marker ensures there's always a newline even if the command output
-  // doesn't have a trailing newline ( e.g. printf 'foo' )
+  // the end marker is printed with the exit code of the eval; the leading newline ensures the marker
+  // starts on its own line even if the command output doesn't have a trailing newline ( e.g. printf 'foo' )
   encodedCmd := base64.StdEncoding.EncodeToString( []byte( command ) )
-  wrappedCmd := fmt.Sprintf( "echo '%s';eval \"$(echo '%s'|base64 -d)\";echo;echo '%s'\n",
-                             shell.startMarker, encodedCmd, shell.endMarker )
+  evalCmd := fmt.Sprintf( "eval \"$(echo '%s'|base64 -d)\"", encodedCmd )
+
+  evalCmd = options.scope( evalCmd )
+
+  // stderr is redirected to a side channel file rather than the PTY; the redirection applies to the
+  // eval only so the session state the command changes is preserved
+  if options.SeparateStderr {
+    stderrFile, err := os.CreateTemp( "", "shelld-stderr-*" )
+    if err != nil {
+      shell.state = StateLocked
+      shell.history.finish( next.id, CommandFailed, nil )
+      return fmt.Errorf( "The stderr file could not be created: %w", err )
+    }
+    stderrFile.Close()
+    shell.stderrPath = stderrFile.Name()
+    if err := shell.credential.own( shell.stderrPath ); err != nil {
+      shell.removeStderr()
+      shell.state = StateLocked
+      shell.history.finish( next.id, CommandFailed, nil )
+      return fmt.Errorf( "The stderr file could not be created: %w", err )
+    }
+    evalCmd = fmt.Sprintf( "%s 2>%s", evalCmd, quote( shell.stderrPath ) )
+  }
+
+  wrappedCmd := fmt.Sprintf( "echo '%s';%s;%s\n",
+                             shell.startMarker, evalCmd, shell.endMarkerCommand() )
   _, err := shell.ptyFile.Write( []byte( wrappedCmd ) )
   if err != nil {
     shell.state = StateUnrecoverable
-    shell.mu.Unlock()
-    return "", fmt.Errorf( "The command could not be written to the shell: %w", err )
+    shell.removeStderr()
+    shell.history.finish( next.id, CommandFailed, nil )
+    return fmt.Errorf( "The command could not be written to the shell: %w", err )
   }
 
   // start background reader
-  go shell.readUntilMarker()
+  go shell.readUntilMarker( shell.ptyFile, next.id, next.done )
 
+  shell.notifyOutput()
+  return nil
+}
+
+// Follow passes the output of the running command to write as it is produced and returns the
+// result once the command completes; if no command is running the output and result of the last
+// completed command are returned
+func ( shell *Shell ) Follow( ctx context.Context, write func( string ) ) ( Result, error ) {
+  shell.mu.Lock()
+  if shell.state != StateExecuting && shell.lastResult == nil {
+    shell.mu.Unlock()
+    return Result{}, ErrNoCommand
+  }
+  commandID := shell.commandID
   shell.mu.Unlock()
 
-  // wait for completion or timeout
-  select {
-  case err := <-shell.commandDone:
+  return shell.FollowCommand( ctx, commandID, write )
+}
+
+// FollowCommand is Follow for the command with the ID; a queued command is followed once it starts
+func ( shell *Shell ) FollowCommand( ctx context.Context, id uint64, write func( string ) ) ( Result, error ) {
+  shell.mu.Lock()
+  for shell.queued( id ) {
+    outputChanged := shell.outputChanged
+    shell.mu.Unlock()
+    select {
+    case <-outputChanged:
+    case <-ctx.Done():
+      return Result{}, ctx.Err()
+    }
     shell.mu.Lock()
-    defer shell.mu.Unlock()
+  }
+  if shell.commandID != id || shell.output == nil {
+    // a command that already completed and was followed by another is passed on as it was returned
+    result := shell.commandResult( id )
+    shell.mu.Unlock()
+    if result == nil {
+      return Result{}, fmt.Errorf( "The followed command is not running." )
+    }
+    write( result.Output )
+    return *result, nil
+  }
+  // the follower keeps reading the output of its command after the next command starts
+  output := shell.output
+  filter := shell.filter
+  shell.mu.Unlock()
+
+  // held is the end of the output that may be the beginning of an escape sequence; it is filtered
+  // together with the output that follows
+  var written int64
+  held := ""
+  for {
+    shell.mu.Lock()
+    chunk, err := output.readAt( written, outputReadSize )
+    running := shell.commandID == id && shell.state == StateExecuting
+    result := shell.commandResult( id )
+    var incomplete error
+    if !running && result == nil {
+      incomplete = shell.incompleteError( id )
+    }
+    outputChanged := shell.outputChanged
+    shell.mu.Unlock()
 
     if err != nil {
-      shell.state = StateUnrecoverable
-      return "", err
+      return Result{}, err
     }
 
-    output := shell.lastOutput
-    shell.state = StateLocked
-    return output, nil
+    // the output is passed on in pieces so a follower that fell behind catches up from the spill
+    // file without reading all of it at once
+    if len( chunk ) > 0 {
+      written += int64( len( chunk ) )
+      var filtered string
+      filtered, held = applyStreamFilter( held+string( chunk ), filter )
+      if filtered != "" {
+        write( filtered )
+      }
+      continue
+    }
 
-  case <-time.After( timeout ):
-    // timeout - shell stays busy, reader continues in background
-    return "", ErrTimeout
+    if !running {
+      if result == nil {
+        return Result{}, incomplete
+      }
+      return *result, nil
+    }
+
+    select {
+    case <-outputChanged:
+    case <-ctx.Done():
+      return Result{}, ctx.Err()
+    }
   }
 }
 
-// Output returns the output from the last completed command
-func ( shell *Shell ) Output() string {
+// Wait blocks until the running command completes and returns its result; if no command is
+// running the result of the last completed command is returned. The ID of the command is returned
+// with the error when the context ends first
+func ( shell *Shell ) Wait( ctx context.Context ) ( Result, error ) {
   shell.mu.Lock()
   defer shell.mu.Unlock()
-  return shell.lastOutput
+
+  if shell.state != StateExecuting {
+    if shell.lastResult == nil {
+      return Result{}, ErrNoCommand
+    }
+    return *shell.lastResult, nil
+  }
+
+  // the reader wakes everyone waiting on the command when it completes
+  id := shell.commandID
+  for {
+    if result := shell.commandResult( id ); result != nil {
+      return *result, nil
+    }
+    if shell.commandID != id || shell.state != StateExecuting {
+      return Result{ ID: id }, shell.incompleteError( id )
+    }
+
+    outputChanged := shell.outputChanged
+    shell.mu.Unlock()
+    select {
+    case <-outputChanged:
+      shell.mu.Lock()
+    case <-ctx.Done():
+      shell.mu.Lock()
+      return Result{ ID: id }, ctx.Err()
+    }
+  }
+}
+
+// commandResult returns the result of the completed command with the ID or nil if it has not
+// completed; the caller holds the lock
+func ( shell *Shell ) commandResult( id uint64 ) *Result {
+  if shell.lastResult != nil && shell.lastResult.ID == id {
+    return shell.lastResult
+  }
+  if entry, err := shell.history.get( id ); err == nil {
+    return entry.Result
+  }
+  return nil
+}
+
+// Output returns the result of the last completed command or nil if there is none
+func ( shell *Shell ) Output() *Result {
+  shell.mu.Lock()
+  defer shell.mu.Unlock()
+
+  if shell.lastResult == nil {
+    return nil
+  }
+  result := *shell.lastResult
+  return &result
+}
+
+// History returns the records of the most recent commands, oldest first
+func ( shell *Shell ) History() []HistoryEntry {
+  shell.mu.Lock()
+  defer shell.mu.Unlock()
+
+  return shell.history.list()
+}
+
+// HistoryEntry returns the record of the command with the ID
+func ( shell *Shell ) HistoryEntry( id uint64 ) ( HistoryEntry, error ) {
+  shell.mu.Lock()
+  defer shell.mu.Unlock()
+
+  return shell.history.get( id )
+}
+
+// AwaitingInput reports whether the running command appears to be waiting for input: it has not
+// produced output for a while and a process in the foreground is blocked reading the terminal
+func ( shell *Shell ) AwaitingInput() bool {
+  shell.mu.Lock()
+  if shell.state != StateExecuting || time.Since( shell.lastOutput ) < awaitingInputQuietPeriod ||
+     shell.ptyFile == nil || shell.cmd == nil || shell.cmd.Process == nil {
+    shell.mu.Unlock()
+    return false
+  }
+  processGroup, err := foregroundProcessGroup( shell.ptyFile )
+  shellPid := shell.cmd.Process.Pid
+  shell.mu.Unlock()
+
+  if err != nil {
+    return false
+  }
+  return processGroupReadingTerminal( processGroup, shellPid )
+}
+
+// OpenOutput opens the complete output of the running or last command as it was before filtering
+// and truncation; output that did not fit in the buffer is read from the spill file
+func ( shell *Shell ) OpenOutput() ( io.ReadSeekCloser, error ) {
+  shell.mu.Lock()
+  defer shell.mu.Unlock()
+
+  if shell.output == nil {
+    return nil, ErrNoCommand
+  }
+  return shell.output.open()
+}
+
+// OpenCommandOutput opens the complete output like OpenOutput and returns the ID of the command it
+// belongs to; a non-zero ID must be that of the running or last command, so a caller paging through
+// the output of one command does not carry on in the output of the next
+func ( shell *Shell ) OpenCommandOutput( id uint64 ) ( io.ReadSeekCloser, uint64, error ) {
+  shell.mu.Lock()
+  defer shell.mu.Unlock()
+
+  if shell.output == nil {
+    return nil, 0, ErrNoCommand
+  }
+  if id != 0 && id != shell.commandID {
+    return nil, 0, ErrNotCurrentCommand
+  }
+  reader, err := shell.output.open()
+  return reader, shell.commandID, err
 }
 
 // Kill interrupts the current command by sending Ctrl+C to the PTY
@@ -193,6 +672,29 @@ func ( shell *Shell ) Kill() error {
   shell.mu.Lock()
   defer shell.mu.Unlock()
 
+  return shell.interrupt()
+}
+
+// KillCommand interrupts the command with the ID if it is still running, or removes it from the
+// queue if it has not started
+func ( shell *Shell ) KillCommand( id uint64 ) error {
+  shell.mu.Lock()
+  defer shell.mu.Unlock()
+
+  if shell.cancelQueued( id ) {
+    return nil
+  }
+  if shell.state != StateExecuting || shell.commandID != id {
+    if shell.history.find( id ) == nil {
+      return ErrNotInHistory
+    }
+    return ErrCommandFinished
+  }
+  return shell.interrupt()
+}
+
+// interrupt sends Ctrl+C to the PTY; the caller holds the lock
+func ( shell *Shell ) interrupt() error {
   if shell.ptyFile == nil {
     return nil
   }
@@ -209,17 +711,132 @@ func ( shell *Shell ) Kill() error {
   // the background reader will handle the state transition
   if shell.state == StateExecuting {
     shell.logger.Debug( "Shell | Kill | Waiting for command to be interrupted." )
+    go shell.recoverEndMarker( shell.endMarker )
   }
 
   return nil
 }
 
-// Unlock gracefully terminates the shell
+// KillEscalating stops the running command, escalating from an interrupt to SIGTERM and then
+// SIGKILL sent to the foreground process group whenever the command is still running after the kill
+// grace period; the shell itself is never signalled. The signal that stopped the command is returned
+func ( shell *Shell ) KillEscalating() ( syscall.Signal, error ) {
+  shell.mu.Lock()
+  if shell.state != StateExecuting {
+    shell.mu.Unlock()
+    return 0, ErrCommandFinished
+  }
+  id := shell.commandID
+  err := shell.interrupt()
+  shell.mu.Unlock()
+  if err != nil {
+    return 0, err
+  }
+  if shell.waitStopped( id ) {
+    return syscall.SIGINT, nil
+  }
+
+  for _, signal := range []syscall.Signal{ syscall.SIGTERM, syscall.SIGKILL } {
+    if err := shell.signalForeground( id, signal ); err != nil {
+      return 0, err
+    }
+    if shell.waitStopped( id ) {
+      return signal, nil
+    }
+  }
+  return 0, ErrKillFailed
+}
+
+// signalForeground sends the signal to the foreground process group of the command with the ID
+func ( shell *Shell ) signalForeground( id uint64, signal syscall.Signal ) error {
+  shell.mu.Lock()
+  defer shell.mu.Unlock()
+
+  if shell.commandID != id || shell.state != StateExecuting ||
+     shell.ptyFile == nil || shell.cmd == nil || shell.cmd.Process == nil {
+    return nil
+  }
+
+  processGroup, err := foregroundProcessGroup( shell.ptyFile )
+  if err != nil {
+    return fmt.Errorf( "The foreground process group could not be found: %w", err )
+  }
+  // a builtin or a function runs in the shell, which only an interrupt can stop
+  if processGroup == shell.cmd.Process.Pid {
+    return fmt.Errorf( "%w The command runs in the shell itself.", ErrKillFailed )
+  }
+
+  shell.logger.Info( "Shell | KillEscalating | Signalling the foreground process group.",
+                     "process_group", processGroup, "signal", signal.String() )
+  if err := syscall.Kill( -processGroup, signal ); err != nil && err != syscall.ESRCH {
+    return fmt.Errorf( "The signal could not be sent: %w", err )
+  }
+  return nil
+}
+
+// waitStopped waits up to the kill grace period for the command with the ID to stop running and
+// reports whether it did
+func ( shell *Shell ) waitStopped( id uint64 ) bool {
+  deadline := time.After( shell.killGracePeriod )
+
+  shell.mu.Lock()
+  defer shell.mu.Unlock()
+  for shell.commandID == id && shell.state == StateExecuting {
+    outputChanged := shell.outputChanged
+    shell.mu.Unlock()
+    select {
+    case <-outputChanged:
+      shell.mu.Lock()
+    case <-deadline:
+      shell.mu.Lock()
+      return shell.commandID != id || shell.state != StateExecuting
+    }
+  }
+  return true
+}
+
+// Input writes data to the PTY of the running command, optionally followed by a newline and an
+// end of file ( Ctrl+D ), so the command can be answered instead of killed
+func ( shell *Shell ) Input( data []byte, newline bool, eof bool ) error {
+  shell.mu.Lock()
+  defer shell.mu.Unlock()
+
+  if shell.state != StateExecuting || shell.ptyFile == nil {
+    return fmt.Errorf( "%w ( state: %s )", ErrNotExecuting, shell.state )
+  }
+
+  input := append( []byte{}, data... )
+  if newline {
+    input = append( input, '\n' )
+  }
+  if eof {
+    // the terminal only treats Ctrl+D as end of file at the start of a line, so a pending partial
+    // line is flushed by a first Ctrl+D and ended by a second
+    if len( input ) > 0 && input[len( input )-1] != '\n' {
+      input = append( input, 0x04 )
+    }
+    input = append( input, 0x04 )
+  }
+
+  shell.logger.Debug( "Shell | Input | Writing input to the command.", "length", len( input ) )
+
+  if _, err := shell.ptyFile.Write( input ); err != nil {
+    return fmt.Errorf( "The input could not be written to the shell: %w", err )
+  }
+  return nil
+}
+
+// Unlock gracefully terminates the shell and the background processes and discards the history
 func ( shell *Shell ) Unlock() error {
+  shell.stopProcesses()
+
   shell.mu.Lock()
   defer shell.mu.Unlock()
 
+  shell.failQueue( ErrShellClosed )
+  shell.history.clear()
   if shell.cmd == nil || shell.cmd.Process == nil {
+    shell.removeCgroup()
     shell.state = StateAvailable
     return nil
   }
@@ -249,57 +866,263 @@ func ( shell *Shell ) Unlock() error {
     <-done
   }
 
+  if shell.terminal != nil {
+    shell.terminal.close()
+    shell.terminal = nil
+  }
+
   shell.cmd = nil
   shell.outputBuffer.Reset()
+  shell.closeOutput()
+  shell.removeStderr()
+  shell.removeCgroup()
   shell.state = StateAvailable
+  shell.notifyOutput()
   return nil
 }
 
-// readUntilMarker reads from PTY until the end marker output is found
-func ( shell *Shell ) readUntilMarker() {
-  buf := make( []byte, 4096 )
-  // look for end marker as OUTPUT (at start of line, followed by newline)
-  // this distinguishes from the end marker appearing in the command echo
-  endMarkerOutput := []byte( "\n" + shell.endMarker + "\r\n" )
+// removeCgroup kills whatever the shell left running in its cgroup and removes the cgroup; the
+// caller holds the lock
+func ( shell *Shell ) removeCgroup() {
+  if shell.cgroup == nil {
+    return
+  }
+  if err := shell.cgroup.remove(); err != nil {
+    shell.logger.Warn( "Shell | RemoveCgroup | The shell cgroup could not be removed.", "error", err )
+  }
+  shell.cgroup = nil
+}
+
+// recoverEndMarker prints the end marker if an interrupt aborted the command before it was printed
+//
+// an interactive shell abandons the remainder of the command line when a command is interrupted,
+// which includes the end marker; once the shell is back at its prompt the end marker is typed
+// again so that the background reader sees the command complete. A command that survives the
+// interrupt never gives the prompt back, so nothing is typed and an escalating kill goes on to
+// signal it
+func ( shell *Shell ) recoverEndMarker( endMarker string ) {
+  deadline := time.Now().Add( shell.killGracePeriod )
+
+  for time.Now().Before( deadline ) {
+    time.Sleep( 50 * time.Millisecond )
 
-  for {
     shell.mu.Lock()
-    if shell.ptyFile == nil {
+    if shell.state != StateExecuting || shell.endMarker != endMarker ||
+       shell.ptyFile == nil || shell.cmd == nil || shell.cmd.Process == nil {
+      shell.mu.Unlock()
+      return
+    }
+
+    if shell.atPrompt() {
+      shell.logger.Debug( "Shell | RecoverEndMarker | The shell is back at its prompt, printing end marker." )
+      shell.ptyFile.Write( []byte( shell.endMarkerCommand() + "\n" ) )
       shell.mu.Unlock()
-      shell.commandDone <- fmt.Errorf( "The shell was closed." )
       return
     }
-    ptyFile := shell.ptyFile
     shell.mu.Unlock()
+  }
+}
 
+// atPrompt reports whether the shell is back at its prompt: it has the terminal in the foreground
+// and is blocked waiting for input from it; a shell that has the terminal between the children of a
+// command that carries on is running or waiting for them instead. The caller holds the lock
+func ( shell *Shell ) atPrompt() bool {
+  pid := shell.cmd.Process.Pid
+  processGroup, err := foregroundProcessGroup( shell.ptyFile )
+  if err != nil || processGroup != pid {
+    return false
+  }
+  terminal, err := os.Readlink( fmt.Sprintf( "/proc/%d/fd/0", pid ) )
+  return err == nil && waitingForTerminal( pid, terminal )
+}
+
+// readUntilMarker reads from PTY until the end marker output is found
+func ( shell *Shell ) readUntilMarker( ptyFile *os.File, commandID uint64, commandDone chan commandOutcome ) {
+  buf := make( []byte, 4096 )
+
+  for {
     bytesRead, err := ptyFile.Read( buf )
     if err != nil {
       if err == io.EOF {
-        shell.commandDone <- fmt.Errorf( "The shell process terminated unexpectedly." )
+        shell.failCommand( ptyFile, commandID, commandDone, fmt.Errorf( "The shell process terminated unexpectedly." ) )
       } else {
-        shell.commandDone <- fmt.Errorf( "The shell read failed: %w", err )
+        shell.failCommand( ptyFile, commandID, commandDone, fmt.Errorf( "The shell read failed: %w", err ) )
       }
       return
     }
 
     shell.mu.Lock()
+    if shell.ptyFile != ptyFile {
+      shell.history.finish( commandID, CommandFailed, nil )
+      shell.mu.Unlock()
+      commandDone <- commandOutcome{ err: ErrShellClosed }
+      return
+    }
     shell.outputBuffer.Write( buf[:bytesRead] )
-    bufferBytes := shell.outputBuffer.Bytes()
-
-    if bytes.Contains( bufferBytes, endMarkerOutput ) {
-      shell.lastOutput = shell.extractOutput( shell.currentCommand )
+    shell.lastOutput = time.Now()
+    if shell.terminal != nil {
+      shell.terminal.deliver( buf[:bytesRead] )
+    }
+    shell.collectOutput()
+
+    // the exit code follows the end marker so the command is only complete once it has been read; an
+    // interrupt can abort the command line before the start marker is printed, in which case the end
+    // marker is the only output
+    if match := shell.endMarkerPattern.FindSubmatch( shell.outputBuffer.Bytes() ); match != nil {
+      exitCode, _ := strconv.Atoi( string( match[1] ) )
+      shell.readReport( match[2] )
+      output, outputTruncated := shell.extractOutput()
+      separateStderr := shell.stderrPath != ""
+      stderr, stderrTruncated := shell.collectStderr()
+      shell.lastResult = &Result{
+        ID:             commandID,
+        Output:         output,
+        Stderr:         stderr,
+        SeparateStderr: separateStderr,
+        ExitCode:       exitCode,
+        Duration:       time.Since( shell.commandStarted ),
+        Truncated:      outputTruncated || stderrTruncated,
+      }
+      shell.history.finish( commandID, CommandCompleted, shell.lastResult )
       shell.logger.Debug( "Shell | ReadUntilMarker | The command completed.",
-                          "output_length", len( shell.lastOutput ) )
-      // update state to ready here in case Run() has already timed out
-      shell.state = StateLocked
+                          "output_length", len( shell.lastResult.Output ),
+                          "exit_code", exitCode )
+      commandDone <- commandOutcome{ result: shell.lastResult }
+      // update state to ready here in case Run() has already timed out; a terminal attached while
+      // the command was running takes over the shell, otherwise the next queued command starts
+      if shell.terminal != nil {
+        shell.startTerminalReader()
+      } else {
+        shell.state = StateLocked
+        shell.notifyOutput()
+        shell.runQueued()
+      }
+      shell.history.storeOutput( commandID, &shell.mu )
       shell.mu.Unlock()
-      shell.commandDone <- nil
       return
     }
+    shell.notifyOutput()
     shell.mu.Unlock()
   }
 }
 
+// collectOutput moves the command output read so far from the PTY buffer to the output store; the
+// command echo and the start marker before the output are dropped and the output ends at the first
+// end marker
+func ( shell *Shell ) collectOutput() {
+  if shell.outputClosed {
+    return
+  }
+
+  if !shell.outputStarted {
+    startMarkerOutput := []byte( shell.startMarker + "\r\n" )
+    startIdx := bytes.Index( shell.outputBuffer.Bytes(), startMarkerOutput )
+    if startIdx == -1 {
+      return
+    }
+    shell.outputBuffer.Next( startIdx + len( startMarkerOutput ) )
+    shell.outputStarted = true
+  }
+
+  pending := shell.outputBuffer.Bytes()
+  length := len( pending )
+  if endIdx := bytes.Index( pending, []byte( shell.endMarker ) ); endIdx != -1 {
+    // the line break the end marker is printed after is not part of the output; it stays in the
+    // buffer since the end marker pattern includes it
+    length = endIdx
+    if bytes.HasSuffix( pending[:endIdx], []byte( "\r\n" ) ) {
+      length -= 2
+    }
+    shell.outputClosed = true
+  } else {
+    // a trailing part that may be the beginning of the end marker is held back; this includes a
+    // carriage return whose line feed has not been read yet
+    length -= max( partialSuffix( pending, "\r\n"+shell.endMarker ), partialSuffix( pending, shell.endMarker ) )
+  }
+
+  if length == 0 {
+    return
+  }
+  if err := shell.output.write( bytes.ReplaceAll( pending[:length], []byte( "\r\n" ), []byte( "\n" ) ) ); err != nil {
+    shell.logger.Error( "Shell | CollectOutput | The output could not be stored.", "error", err )
+  }
+  shell.outputBuffer.Next( length )
+}
+
+// partialSuffix returns the length of the longest end of data that is the beginning of marker
+func partialSuffix( data []byte, marker string ) int {
+  for length := min( len( marker ), len( data ) ); length > 0; length-- {
+    if bytes.HasSuffix( data, []byte( marker[:length] ) ) {
+      return length
+    }
+  }
+  return 0
+}
+
+// failCommand records a reader failure; the state is updated here as well in case nobody is
+// waiting on the command
+func ( shell *Shell ) failCommand( ptyFile *os.File, commandID uint64, commandDone chan commandOutcome, err error ) {
+  shell.mu.Lock()
+  // a shell that was unlocked while the command was running has not failed
+  if shell.ptyFile == ptyFile && shell.state == StateExecuting {
+    shell.state = StateUnrecoverable
+    if shell.restartPolicy.Automatic {
+      err = shell.restart( commandID, err )
+    }
+    shell.failQueue( err )
+  }
+  shell.history.finish( commandID, CommandFailed, nil )
+  shell.notifyOutput()
+  shell.mu.Unlock()
+  commandDone <- commandOutcome{ err: err }
+}
+
+// restart replaces the failed shell with a fresh one and returns the error reported for the command
+// that was running, or the cause if the shell could not be restarted; the caller holds the lock
+func ( shell *Shell ) restart( commandID uint64, cause error ) error {
+  shell.logger.Warn( "Shell | Restart | The shell failed and is restarting.", "error", cause )
+
+  if shell.cmd != nil && shell.cmd.Process != nil {
+    shell.cmd.Process.Kill()
+    shell.cmd.Wait()
+  }
+  if shell.terminal != nil {
+    shell.terminal.close()
+    shell.terminal = nil
+  }
+  shell.cleanup()
+
+  if err := shell.start(); err != nil {
+    shell.logger.Error( "Shell | Restart | The shell could not be restarted.", "error", err )
+    return cause
+  }
+  shell.restarts++
+  shell.restartedCommand = commandID
+  return fmt.Errorf( "%w %w", ErrRestarted, cause )
+}
+
+// incompleteError returns the error for a command that ended without a result; the caller holds the
+// lock
+func ( shell *Shell ) incompleteError( id uint64 ) error {
+  if id != 0 && shell.restartedCommand == id {
+    return fmt.Errorf( "%w The command did not complete.", ErrRestarted )
+  }
+  return fmt.Errorf( "The command did not complete ( state: %s ).", shell.state )
+}
+
+// Restarts returns how many times the shell was restarted since it was started
+func ( shell *Shell ) Restarts() int {
+  shell.mu.Lock()
+  defer shell.mu.Unlock()
+  return shell.restarts
+}
+
+// notifyOutput wakes everyone waiting for new output or a state change of the current command
+func ( shell *Shell ) notifyOutput() {
+  close( shell.outputChanged )
+  shell.outputChanged = make( chan struct{} )
+}
+
 // waitForOutput waits for a specific string to appear in the output
 func ( shell *Shell ) waitForOutput( marker string, timeout time.Duration ) error {
   done := make( chan error, 1 )
@@ -339,37 +1162,86 @@ func ( shell *Shell ) waitForOutput( marker string, timeout time.Duration ) erro
   }
 }
 
-// extractOutput extracts the command output from between the start and end markers
-func ( shell *Shell ) extractOutput( command string ) string {
-  output := shell.outputBuffer.String()
+// extractOutput returns the output of the completed command; when the output did not fit in the
+// buffer only its head and tail are included, joined before they are filtered, and the result is cut
+// to the returned bytes limit
+func ( shell *Shell ) extractOutput() ( string, bool ) {
+  head := string( shell.output.head )
+  tail := string( shell.output.tail )
+
+  var output string
+  truncated := false
+  if omitted := shell.output.omitted(); omitted > 0 {
+    output = shell.formatOutput( joinTruncated( head, tail, omitted ) )
+    truncated = true
+  } else {
+    output = shell.formatOutput( head + tail )
+  }
+
+  output, returnedTruncated := truncateMiddle( output, shell.outputLimits.MaximumReturned )
+  shell.logger.Debug( "Shell | ExtractOutput | Final result.", "result", output )
+  return output, truncated || returnedTruncated
+}
+
+// formatOutput applies the filter to the output and removes empty lines unless exact output was
+// requested
+func ( shell *Shell ) formatOutput( output string ) string {
+  output = applyFilter( output, shell.filter )
+  if !shell.exactOutput {
+    output = cleanLines( output )
+  }
+  return output
+}
+
+// collectStderr reads and removes the stderr side channel file of the current command; like the
+// output only the head and tail of a file larger than the buffer are read
+func ( shell *Shell ) collectStderr() ( string, bool ) {
+  if shell.stderrPath == "" {
+    return "", false
+  }
+  defer shell.removeStderr()
 
-  shell.logger.Debug( "Shell | ExtractOutput | Raw buffer.",
-                      "raw", output,
-                      "startMarker", shell.startMarker,
-                      "endMarker", shell.endMarker )
+  stderr, omitted, err := readHeadAndTail( shell.stderrPath, shell.outputLimits.MaximumBuffered )
+  if err != nil {
+    shell.logger.Error( "Shell | CollectStderr | The stderr file could not be read.", "error", err )
+    return "", false
+  }
 
-  // find the start marker output (not the echo, but the actual output)
-  startMarkerOutput := shell.startMarker + "\r\n"
-  startIdx := strings.Index( output, startMarkerOutput )
-  if startIdx == -1 {
-    shell.logger.Debug( "Shell | ExtractOutput | Start marker not found." )
-    return ""
+  // stderr does not pass through the terminal so there is no line break translation to undo
+  var result string
+  if omitted > 0 {
+    half := shell.outputLimits.MaximumBuffered / 2
+    result = shell.formatOutput( joinTruncated( string( stderr[:half] ), string( stderr[half:] ), omitted ) )
+  } else {
+    result = shell.formatOutput( string( stderr ) )
   }
 
-  // take everything after the start marker
-  output = output[startIdx+len( startMarkerOutput ):]
+  result, returnedTruncated := truncateMiddle( result, shell.outputLimits.MaximumReturned )
+  return result, omitted > 0 || returnedTruncated
+}
 
-  // find the end marker output
-  endIdx := strings.Index( output, shell.endMarker )
-  if endIdx == -1 {
-    shell.logger.Debug( "Shell | ExtractOutput | End marker not found." )
-    return ""
+// closeOutput discards the output stores of the last commands and removes their spill files
+func ( shell *Shell ) closeOutput() {
+  if shell.output != nil {
+    shell.output.close()
+    shell.output = nil
+  }
+  if shell.previousOutput != nil {
+    shell.previousOutput.close()
+    shell.previousOutput = nil
   }
+}
 
-  // take everything before the end marker
-  output = output[:endIdx]
+// removeStderr removes the stderr side channel file if there is one
+func ( shell *Shell ) removeStderr() {
+  if shell.stderrPath != "" {
+    os.Remove( shell.stderrPath )
+    shell.stderrPath = ""
+  }
+}
 
-  // clean up lines
+// cleanLines strips carriage returns and drops empty lines
+func cleanLines( output string ) string {
   lines := strings.Split( output, "\n" )
   var cleanLines []string
   for _, line := range lines {
@@ -378,12 +1250,38 @@ func ( shell *Shell ) extractOutput( command string ) string {
       cleanLines = append( cleanLines, line )
     }
   }
+  return strings.Join( cleanLines, "\n" )
+}
+
+// endMarkerCommand returns the shell command that prints the end marker, the exit code of the
+// preceding command and the session report; the exit code is expanded before the report is run
+func ( shell *Shell ) endMarkerCommand() string {
+  return fmt.Sprintf( "printf '\\n%s%%d:%%s\\n' $? \"$(%s)\"", shell.endMarker, shell.reportCommand() )
+}
+
+// foregroundProcessGroup returns the foreground process group of the terminal behind the PTY
+func foregroundProcessGroup( ptyFile *os.File ) ( int, error ) {
+  rawConn, err := ptyFile.SyscallConn()
+  if err != nil {
+    return 0, err
+  }
 
-  result := strings.Join( cleanLines, "\n" )
-  shell.logger.Debug( "Shell | ExtractOutput | Final result.",
-                      "result", result,
-                      "cleanLines", cleanLines )
-  return result
+  // the raw connection is used instead of Fd() which would switch the PTY to blocking mode
+  var processGroup int32
+  var errno syscall.Errno
+  err = rawConn.Control( func( fd uintptr ) {
+    _, _, errno = syscall.Syscall( syscall.SYS_IOCTL,
+                                   fd,
+                                   uintptr( syscall.TIOCGPGRP ),
+                                   uintptr( unsafe.Pointer( &processGroup ) ) )
+  } )
+  if err != nil {
+    return 0, err
+  }
+  if errno != 0 {
+    return 0, errno
+  }
+  return int( processGroup ), nil
 }
 
 // cleanup releases PTY and process resources
@@ -394,4 +1292,6 @@ func ( shell *Shell ) cleanup() {
   }
   shell.cmd = nil
   shell.outputBuffer.Reset()
+  shell.closeOutput()
+  shell.removeStderr()
 }
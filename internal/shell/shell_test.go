@@ -1,9 +1,13 @@
 package shell
 
 import (
+  "context"
+  "errors"
+  "io"
   "log/slog"
   "os"
   "strings"
+  "syscall"
   "testing"
   "time"
 )
@@ -13,7 +17,7 @@ func newTestShell( t *testing.T ) *Shell {
   logger := slog.New( slog.NewTextHandler( os.Stderr, &slog.HandlerOptions{
     Level: slog.LevelError,
   } ) )
-  return NewShell( "/bin/bash", "", 5*time.Second, logger )
+  return NewShell( Config{ Command: "/bin/bash", KillGracePeriod: 5*time.Second, HistorySize: 10 }, logger )
 }
 
 func TestNewShell( t *testing.T ) {
@@ -58,21 +62,347 @@ func TestShellRunCommand( t *testing.T ) {
     t.Fatalf( "The shell failed to start: %v", err )
   }
 
-  output, err := shell.Execute( "echo hello", 30*time.Second )
+  result, err := shell.Execute( "echo hello", 30*time.Second, Options{} )
   if err != nil {
     t.Fatalf( "The command failed to run: %v", err )
   }
 
-  output = strings.TrimSpace( output )
+  output := strings.TrimSpace( result.Output )
   if output != "hello" {
     t.Errorf( "The output should be 'hello', but got '%s'.", output )
   }
 }
 
+func TestShellExitCode( t *testing.T ) {
+  shell := newTestShell( t )
+  defer shell.Unlock()
+
+  if err := shell.Start(); err != nil {
+    t.Fatalf( "The shell failed to start: %v", err )
+  }
+
+  result, err := shell.Execute( "true", 30*time.Second, Options{} )
+  if err != nil {
+    t.Fatalf( "The command failed to run: %v", err )
+  }
+  if result.ExitCode != 0 {
+    t.Errorf( "The exit code should be 0, but got %d.", result.ExitCode )
+  }
+
+  result, err = shell.Execute( "echo failing; ( exit 3 )", 30*time.Second, Options{} )
+  if err != nil {
+    t.Fatalf( "The command failed to run: %v", err )
+  }
+  if result.ExitCode != 3 {
+    t.Errorf( "The exit code should be 3, but got %d.", result.ExitCode )
+  }
+  if strings.TrimSpace( result.Output ) != "failing" {
+    t.Errorf( "The output should be 'failing', but got '%s'.", result.Output )
+  }
+
+  last := shell.Output()
+  if last == nil || last.ExitCode != 3 {
+    t.Errorf( "The last result should have exit code 3, but got %v.", last )
+  }
+}
+
+func TestShellSeparateStderr( t *testing.T ) {
+  shell := newTestShell( t )
+  defer shell.Unlock()
+
+  if err := shell.Start(); err != nil {
+    t.Fatalf( "The shell failed to start: %v", err )
+  }
+
+  command := "export STDERR_TEST=kept; echo to_stdout; echo to_stderr >&2"
+  result, err := shell.Execute( command, 30*time.Second, Options{ SeparateStderr: true } )
+  if err != nil {
+    t.Fatalf( "The command failed to run: %v", err )
+  }
+  if result.Output != "to_stdout" {
+    t.Errorf( "The output should be 'to_stdout', but got '%s'.", result.Output )
+  }
+  if result.Stderr != "to_stderr" || !result.SeparateStderr {
+    t.Errorf( "The stderr should be separated as 'to_stderr', but got '%s'.", result.Stderr )
+  }
+
+  // the session state changed by the command is preserved
+  result, err = shell.Execute( "echo $STDERR_TEST", 30*time.Second, Options{} )
+  if err != nil || result.SeparateStderr {
+    t.Fatalf( "The command failed to run without separating stderr: %v", err )
+  }
+  if result.Output != "kept" {
+    t.Errorf( "The variable should be 'kept', but got '%s'.", result.Output )
+  }
+}
+
+func TestShellExactOutput( t *testing.T ) {
+  shell := newTestShell( t )
+  defer shell.Unlock()
+
+  if err := shell.Start(); err != nil {
+    t.Fatalf( "The shell failed to start: %v", err )
+  }
+
+  tests := []struct {
+    command  string
+    options  Options
+    expected string
+  }{
+    { "printf 'a\\n\\n  b\\n\\n'", Options{}, "a\n  b" },
+    { "printf 'a\\n\\n  b\\n\\n'", Options{ ExactOutput: true }, "a\n\n  b\n\n" },
+    { "printf 'no newline'", Options{ ExactOutput: true }, "no newline" },
+    { "printf 'crlf\\r\\n'", Options{ ExactOutput: true }, "crlf\r\n" },
+    { "true", Options{ ExactOutput: true }, "" },
+  }
+
+  for _, test := range tests {
+    result, err := shell.Execute( test.command, 30*time.Second, test.options )
+    if err != nil {
+      t.Fatalf( "The command failed to run: %v", err )
+    }
+    if result.Output != test.expected {
+      t.Errorf( "The output of %s should be %q, but got %q.", test.command, test.expected, result.Output )
+    }
+  }
+
+  result, err := shell.Execute( "printf 'x\\n\\n' >&2", 30*time.Second,
+                                Options{ SeparateStderr: true, ExactOutput: true } )
+  if err != nil {
+    t.Fatalf( "The command failed to run: %v", err )
+  }
+  if result.Stderr != "x\n\n" {
+    t.Errorf( "The stderr should be %q, but got %q.", "x\n\n", result.Stderr )
+  }
+}
+
+func TestShellFilter( t *testing.T ) {
+  shell := newTestShell( t )
+  defer shell.Unlock()
+
+  if err := shell.Start(); err != nil {
+    t.Fatalf( "The shell failed to start: %v", err )
+  }
+
+  command := "printf '\\033[31mred\\033[0m\\n'; printf '10%%\\r50%%\\r100%%\\n'"
+  tests := []struct {
+    options  Options
+    expected string
+  }{
+    { Options{}, "\x1b[31mred\x1b[0m\n10%\r50%\r100%" },
+    { Options{ Filter: FilterStrip }, "red\n10%50%100%" },
+    { Options{ Filter: FilterRender }, "red\n100%" },
+  }
+
+  for _, test := range tests {
+    result, err := shell.Execute( command, 30*time.Second, test.options )
+    if err != nil {
+      t.Fatalf( "The command failed to run: %v", err )
+    }
+    if result.Output != test.expected {
+      t.Errorf( "The output with filter %s should be %q, but got %q.", test.options.Filter, test.expected, result.Output )
+    }
+  }
+}
+
+func TestShellFollow( t *testing.T ) {
+  shell := newTestShell( t )
+  defer shell.Unlock()
+
+  if err := shell.Start(); err != nil {
+    t.Fatalf( "The shell failed to start: %v", err )
+  }
+
+  if _, err := shell.Follow( context.Background(), func( string ) {} ); err != ErrNoCommand {
+    t.Errorf( "Following without a command should fail with ErrNoCommand, but got: %v", err )
+  }
+
+  if _, err := shell.Submit( "echo one; sleep 0.5; printf two; exit_code() { return 5; }; exit_code", Options{} ); err != nil {
+    t.Fatalf( "The command failed to submit: %v", err )
+  }
+
+  var chunks []string
+  result, err := shell.Follow( context.Background(), func( chunk string ) {
+    chunks = append( chunks, chunk )
+  } )
+  if err != nil {
+    t.Fatalf( "The command failed to follow: %v", err )
+  }
+
+  if len( chunks ) < 2 {
+    t.Errorf( "The output should arrive in several chunks, but got %v.", chunks )
+  }
+  if output := strings.Join( chunks, "" ); output != "one\ntwo" {
+    t.Errorf( "The streamed output should be 'one\\ntwo', but got '%s'.", output )
+  }
+  if result.ExitCode != 5 {
+    t.Errorf( "The exit code should be 5, but got %d.", result.ExitCode )
+  }
+  if shell.State() != StateLocked {
+    t.Errorf( "The state should be Ready after the command, but got %s.", shell.State() )
+  }
+}
+
+func TestShellOutputLimits( t *testing.T ) {
+  logger := slog.New( slog.NewTextHandler( os.Stderr, &slog.HandlerOptions{
+    Level: slog.LevelError,
+  } ) )
+  shell := NewShell( Config{
+    Command:         "/bin/bash",
+    KillGracePeriod: 5*time.Second,
+    OutputLimits:    OutputLimits{ MaximumBuffered: 4096, MaximumReturned: 1024 },
+  }, logger )
+  defer shell.Unlock()
+
+  if err := shell.Start(); err != nil {
+    t.Fatalf( "The shell failed to start: %v", err )
+  }
+
+  // the complete output of seq is 48894 bytes
+  result, err := shell.Execute( "seq 1 10000", 30*time.Second, Options{} )
+  if err != nil {
+    t.Fatalf( "The command failed to run: %v", err )
+  }
+  if !result.Truncated {
+    t.Error( "The output should be truncated." )
+  }
+  if !strings.HasPrefix( result.Output, "1\n2\n3\n" ) || !strings.HasSuffix( result.Output, "9999\n10000" ) {
+    t.Errorf( "The output should keep its head and tail, but got '%s'.", result.Output )
+  }
+  if !strings.Contains( result.Output, "bytes truncated ...]" ) || len( result.Output ) > 1100 {
+    t.Errorf( "The output should be cut to about 1024 bytes with a notice, but got %d bytes.", len( result.Output ) )
+  }
+
+  // the complete output is kept in the spill file
+  reader, err := shell.OpenOutput()
+  if err != nil {
+    t.Fatalf( "The output could not be opened: %v", err )
+  }
+  defer reader.Close()
+  complete, err := io.ReadAll( reader )
+  if err != nil {
+    t.Fatalf( "The output could not be read: %v", err )
+  }
+  lines := strings.Split( strings.TrimSuffix( string( complete ), "\n" ), "\n" )
+  if len( complete ) != 48894 || len( lines ) != 10000 || lines[9999] != "10000" {
+    t.Errorf( "The complete output should have 10000 lines in 48894 bytes, but got %d lines in %d bytes.",
+              len( lines ), len( complete ) )
+  }
+
+  // following the completed command streams the complete output
+  followed := 0
+  if _, err := shell.Follow( context.Background(), func( chunk string ) {
+    followed += len( chunk )
+  } ); err != nil {
+    t.Fatalf( "The command failed to follow: %v", err )
+  }
+  if followed != 48894 {
+    t.Errorf( "Following should stream 48894 bytes, but got %d.", followed )
+  }
+
+  result, err = shell.Execute( "echo small", 30*time.Second, Options{} )
+  if err != nil {
+    t.Fatalf( "The command failed to run: %v", err )
+  }
+  if result.Truncated || result.Output != "small" {
+    t.Errorf( "A small output should not be truncated, but got '%s'.", result.Output )
+  }
+}
+
+func TestShellInput( t *testing.T ) {
+  shell := newTestShell( t )
+  defer shell.Unlock()
+
+  if err := shell.Start(); err != nil {
+    t.Fatalf( "The shell failed to start: %v", err )
+  }
+
+  if err := shell.Input( []byte( "ignored" ), true, false ); !errors.Is( err, ErrNotExecuting ) {
+    t.Errorf( "Input without a running command should fail with ErrNotExecuting, but got: %v", err )
+  }
+
+  // answer a prompt
+  if _, err := shell.Submit( "read -p 'Continue? ' answer; echo \"answer=$answer\"", Options{} ); err != nil {
+    t.Fatalf( "The command failed to submit: %v", err )
+  }
+  if err := shell.Input( []byte( "y" ), true, false ); err != nil {
+    t.Fatalf( "The input failed to write: %v", err )
+  }
+  result, err := shell.Follow( context.Background(), func( string ) {} )
+  if err != nil {
+    t.Fatalf( "The command failed to complete: %v", err )
+  }
+  if !strings.HasSuffix( result.Output, "answer=y" ) {
+    t.Errorf( "The command should receive the answer, but got '%s'.", result.Output )
+  }
+
+  // end of file after a partial line
+  if _, err := shell.Submit( "cat | wc -c", Options{} ); err != nil {
+    t.Fatalf( "The command failed to submit: %v", err )
+  }
+
+  // wait for cat to read from the terminal; an end of file typed ahead would reach the shell itself
+  time.Sleep( 500 * time.Millisecond )
+
+  if err := shell.Input( []byte( "abc" ), false, true ); err != nil {
+    t.Fatalf( "The input failed to write: %v", err )
+  }
+  result, err = shell.Follow( context.Background(), func( string ) {} )
+  if err != nil {
+    t.Fatalf( "The command failed to complete: %v", err )
+  }
+  if !strings.HasSuffix( result.Output, "3" ) {
+    t.Errorf( "The command should count 3 bytes, but got '%s'.", result.Output )
+  }
+}
+
+func TestShellAwaitingInput( t *testing.T ) {
+  shell := newTestShell( t )
+  defer shell.Unlock()
+
+  if err := shell.Start(); err != nil {
+    t.Fatalf( "The shell failed to start: %v", err )
+  }
+
+  // a command that is busy is not waiting for input
+  if _, err := shell.Submit( "sleep 30", Options{} ); err != nil {
+    t.Fatalf( "The command failed to submit: %v", err )
+  }
+  time.Sleep( 1 * time.Second )
+  if shell.AwaitingInput() {
+    t.Errorf( "A sleeping command should not be awaiting input." )
+  }
+  shell.Kill()
+  ctx, cancel := context.WithTimeout( context.Background(), 5*time.Second )
+  defer cancel()
+  if _, err := shell.Follow( ctx, func( string ) {} ); err != nil {
+    t.Fatalf( "The interrupted command should complete: %v", err )
+  }
+
+  // a program at a prompt is waiting for input
+  if _, err := shell.Submit( "echo 'Continue? [y/N]'; head -n 1", Options{} ); err != nil {
+    t.Fatalf( "The command failed to submit: %v", err )
+  }
+  time.Sleep( 1 * time.Second )
+  if !shell.AwaitingInput() {
+    t.Errorf( "A command blocked on a prompt should be awaiting input." )
+  }
+
+  if err := shell.Input( []byte( "y" ), true, false ); err != nil {
+    t.Fatalf( "The input failed to write: %v", err )
+  }
+  if _, err := shell.Follow( ctx, func( string ) {} ); err != nil {
+    t.Fatalf( "The command failed to complete: %v", err )
+  }
+  if shell.AwaitingInput() {
+    t.Errorf( "A completed command should not be awaiting input." )
+  }
+}
+
 func TestShellRunBeforeStart( t *testing.T ) {
   shell := newTestShell( t )
 
-  _, err := shell.Execute( "echo hello", 30*time.Second )
+  _, err := shell.Execute( "echo hello", 30*time.Second, Options{} )
   if err == nil {
     t.Error( "The shell should return an error when running a command before start." )
   }
@@ -86,17 +416,17 @@ func TestShellPersistence( t *testing.T ) {
     t.Fatalf( "The shell failed to start: %v", err )
   }
 
-  _, err := shell.Execute( "export TEST_VAR=myvalue", 30*time.Second )
+  _, err := shell.Execute( "export TEST_VAR=myvalue", 30*time.Second, Options{} )
   if err != nil {
     t.Fatalf( "The export command failed to run: %v", err )
   }
 
-  output, err := shell.Execute( "echo $TEST_VAR", 30*time.Second )
+  result, err := shell.Execute( "echo $TEST_VAR", 30*time.Second, Options{} )
   if err != nil {
     t.Fatalf( "The echo command failed to run: %v", err )
   }
 
-  output = strings.TrimSpace( output )
+  output := strings.TrimSpace( result.Output )
   if output != "myvalue" {
     t.Errorf( "The variable value should be 'myvalue', but got '%s'.", output )
   }
@@ -111,7 +441,7 @@ func TestShellKill( t *testing.T ) {
   }
 
   // start a long-running command that will timeout
-  _, err := shell.Execute( "sleep 30", 100*time.Millisecond )
+  _, err := shell.Execute( "sleep 30", 100*time.Millisecond, Options{} )
   if err != ErrTimeout {
     t.Fatalf( "The command should have timed out: %v", err )
   }
@@ -121,26 +451,325 @@ func TestShellKill( t *testing.T ) {
     t.Errorf( "The state should be Busy after timeout, but got %s.", shell.State() )
   }
 
+  // an interrupt that arrives while bash still prepares the command is lost
+  waitForeground( t, shell )
+
   // kill sends Ctrl+C to interrupt the command
   if err := shell.Kill(); err != nil {
     t.Fatalf( "The shell failed to kill: %v", err )
   }
 
   // wait for the interrupt to take effect
-  time.Sleep( 500 * time.Millisecond )
+  ctx, cancel := context.WithTimeout( context.Background(), 5*time.Second )
+  defer cancel()
+  if _, err := shell.Follow( ctx, func( string ) {} ); err != nil {
+    t.Fatalf( "The interrupted command should complete: %v", err )
+  }
 
   // shell should be ready again after interrupt
   if shell.State() != StateLocked {
     t.Errorf( "The state should be Ready after kill, but got %s.", shell.State() )
   }
 
+  // the interrupted command reports the SIGINT exit code
+  if last := shell.Output(); last == nil || last.ExitCode != 130 {
+    t.Errorf( "The interrupted command should have exit code 130, but got %v.", last )
+  }
+
   // verify shell still works
-  output, err := shell.Execute( "echo still_alive", 30*time.Second )
+  result, err := shell.Execute( "echo still_alive", 30*time.Second, Options{} )
   if err != nil {
     t.Fatalf( "The shell should still work after kill: %v", err )
   }
-  if strings.TrimSpace( output ) != "still_alive" {
-    t.Errorf( "The output should be 'still_alive', but got '%s'.", output )
+  if strings.TrimSpace( result.Output ) != "still_alive" {
+    t.Errorf( "The output should be 'still_alive', but got '%s'.", result.Output )
+  }
+}
+
+func TestShellKillTrappedCommand( t *testing.T ) {
+  shell := newTestShell( t )
+  defer shell.Unlock()
+
+  if err := shell.Start(); err != nil {
+    t.Fatalf( "The shell failed to start: %v", err )
+  }
+
+  // the shell ignores the interrupt and carries on with short children, so the end marker must
+  // not be typed where the command later reads its input
+  _, err := shell.Execute( "trap '' INT; echo ready; for i in $(seq 20); do sleep 0.05; for j in $(seq 2000); do :; done; done; " +
+                           "timeout 1 cat; echo finished", 100*time.Millisecond, Options{} )
+  if err != ErrTimeout {
+    t.Fatalf( "The command should have timed out: %v", err )
+  }
+  waitOutput( t, shell, "ready" )
+  waitForeground( t, shell )
+
+  if err := shell.Kill(); err != nil {
+    t.Fatalf( "The shell failed to kill: %v", err )
+  }
+
+  ctx, cancel := context.WithTimeout( context.Background(), 10*time.Second )
+  defer cancel()
+  result, err := shell.Follow( ctx, func( string ) {} )
+  if err != nil {
+    t.Fatalf( "The trapped command should complete: %v", err )
+  }
+  if !strings.HasSuffix( result.Output, "finished" ) || strings.Contains( result.Output, "SHELLD_END" ) {
+    t.Errorf( "The command should finish without the end marker typed into it, but got '%s'.", result.Output )
+  }
+}
+
+// waitForeground waits until the running command owns the foreground process group of the PTY
+func waitForeground( t *testing.T, shell *Shell ) {
+  t.Helper()
+  for attempt := 0; attempt < 100; attempt++ {
+    shell.mu.Lock()
+    processGroup, err := foregroundProcessGroup( shell.ptyFile )
+    pid := shell.cmd.Process.Pid
+    shell.mu.Unlock()
+    if err == nil && processGroup != pid {
+      return
+    }
+    time.Sleep( 50 * time.Millisecond )
+  }
+  t.Fatalf( "The command never reached the foreground." )
+}
+
+// waitOutput waits until the output of the running command contains the text
+func waitOutput( t *testing.T, shell *Shell, text string ) {
+  t.Helper()
+  for attempt := 0; attempt < 100; attempt++ {
+    reader, err := shell.OpenOutput()
+    if err != nil {
+      t.Fatalf( "The output could not be opened: %v", err )
+    }
+    output, _ := io.ReadAll( reader )
+    reader.Close()
+    if strings.Contains( string( output ), text ) {
+      return
+    }
+    time.Sleep( 50 * time.Millisecond )
+  }
+  t.Fatalf( "The output never contained '%s'.", text )
+}
+
+func TestShellKillEscalating( t *testing.T ) {
+  shell := newTestShell( t )
+  shell.killGracePeriod = 500 * time.Millisecond
+  defer shell.Unlock()
+
+  if err := shell.Start(); err != nil {
+    t.Fatalf( "The shell failed to start: %v", err )
+  }
+
+  if _, err := shell.KillEscalating(); err != ErrCommandFinished {
+    t.Errorf( "Killing without a running command should fail: %v", err )
+  }
+
+  tests := []struct {
+    command  string
+    signal   syscall.Signal
+    exitCode int
+  }{
+    { "echo ready; sleep 30", syscall.SIGINT, 130 },
+    { "bash -c \"trap '' INT; echo ready; sleep 30\"", syscall.SIGTERM, 143 },
+    { "bash -c \"trap '' INT TERM; echo ready; sleep 30\"", syscall.SIGKILL, 137 },
+  }
+  for _, test := range tests {
+    if _, err := shell.Execute( test.command, 100*time.Millisecond, Options{} ); err != ErrTimeout {
+      t.Fatalf( "The command '%s' should have timed out: %v", test.command, err )
+    }
+    // the signals are only ignored once the traps are set
+    waitOutput( t, shell, "ready" )
+    waitForeground( t, shell )
+
+    signal, err := shell.KillEscalating()
+    if err != nil {
+      t.Fatalf( "The command '%s' should have been killed: %v", test.command, err )
+    }
+    if signal != test.signal {
+      t.Errorf( "The command '%s' should have been stopped by %v, but got %v.", test.command, test.signal, signal )
+    }
+    if shell.State() != StateLocked {
+      t.Errorf( "The state should be Locked after the kill, but got %s.", shell.State() )
+    }
+    if last := shell.Output(); last == nil || last.ExitCode != test.exitCode {
+      t.Errorf( "The command '%s' should have exit code %d, but got %v.", test.command, test.exitCode, last )
+    }
+  }
+
+  // the shell survives the signals sent to the commands
+  result, err := shell.Execute( "echo still_alive", 30*time.Second, Options{} )
+  if err != nil {
+    t.Fatalf( "The shell should still work after the kills: %v", err )
+  }
+  if strings.TrimSpace( result.Output ) != "still_alive" {
+    t.Errorf( "The output should be 'still_alive', but got '%s'.", result.Output )
+  }
+}
+
+func TestShellRestart( t *testing.T ) {
+  logger := slog.New( slog.NewTextHandler( os.Stderr, &slog.HandlerOptions{
+    Level: slog.LevelError,
+  } ) )
+  shell := NewShell( Config{
+    Command:         "/bin/bash",
+    KillGracePeriod: 5*time.Second,
+    HistorySize:     10,
+    RestartPolicy:   RestartPolicy{ Automatic: true, InitScript: "export GREETING=hello\ncd /tmp" },
+  }, logger )
+  defer shell.Unlock()
+
+  if err := shell.Start(); err != nil {
+    t.Fatalf( "The shell failed to start: %v", err )
+  }
+
+  // the init script runs when the shell starts
+  result, err := shell.Execute( "echo $GREETING $PWD", 30*time.Second, Options{} )
+  if err != nil {
+    t.Fatalf( "The command failed: %v", err )
+  }
+  if strings.TrimSpace( result.Output ) != "hello /tmp" {
+    t.Errorf( "The init script should have run, but got '%s'.", result.Output )
+  }
+
+  // killing the shell process ends the PTY and the shell is replaced
+  _, err = shell.Execute( "export GREETING=changed; kill -9 $$", 30*time.Second, Options{} )
+  if !errors.Is( err, ErrRestarted ) {
+    t.Fatalf( "The command should report the restart: %v", err )
+  }
+  if shell.State() != StateLocked {
+    t.Errorf( "The state should be Locked after the restart, but got %s.", shell.State() )
+  }
+  if shell.Restarts() != 1 {
+    t.Errorf( "The shell should have restarted once, but got %d.", shell.Restarts() )
+  }
+
+  // the failed command stays in the history and the init script is replayed
+  if entry, err := shell.HistoryEntry( 2 ); err != nil || entry.Status != CommandFailed {
+    t.Errorf( "The failed command should be in the history: %v %v", entry, err )
+  }
+  result, err = shell.Execute( "echo $GREETING $PWD", 30*time.Second, Options{} )
+  if err != nil {
+    t.Fatalf( "The shell should work after the restart: %v", err )
+  }
+  if strings.TrimSpace( result.Output ) != "hello /tmp" {
+    t.Errorf( "The init script should have been replayed, but got '%s'.", result.Output )
+  }
+}
+
+func TestShellFailureWithoutRestart( t *testing.T ) {
+  shell := newTestShell( t )
+  defer shell.Unlock()
+
+  if err := shell.Start(); err != nil {
+    t.Fatalf( "The shell failed to start: %v", err )
+  }
+
+  _, err := shell.Execute( "kill -9 $$", 30*time.Second, Options{} )
+  if err == nil || errors.Is( err, ErrRestarted ) {
+    t.Fatalf( "The command should fail without a restart: %v", err )
+  }
+  if shell.State() != StateUnrecoverable {
+    t.Errorf( "The state should be Unrecoverable, but got %s.", shell.State() )
+  }
+}
+
+func TestShellStatus( t *testing.T ) {
+  logger := slog.New( slog.NewTextHandler( os.Stderr, &slog.HandlerOptions{
+    Level: slog.LevelError,
+  } ) )
+  shell := NewShell( Config{
+    Command:          "/bin/bash",
+    WorkingDirectory: "/",
+    KillGracePeriod:  5*time.Second,
+    HistorySize:      10,
+    StateVariables:   []string{ "HOME", "STATUS_TEST" },
+  }, logger )
+  defer shell.Unlock()
+
+  if err := shell.Start(); err != nil {
+    t.Fatalf( "The shell failed to start: %v", err )
+  }
+
+  // the session is reported before the first command
+  status := shell.Status()
+  if status.WorkingDirectory != "/" || status.Environment["HOME"] == "" || status.PID == 0 {
+    t.Errorf( "The status should report the new shell, but got %+v.", status )
+  }
+  if _, ok := status.Environment["STATUS_TEST"]; ok {
+    t.Errorf( "A variable that is not set should not be reported, but got %+v.", status.Environment )
+  }
+  if status.LastCommandID != 0 {
+    t.Errorf( "No command should have completed, but got %d.", status.LastCommandID )
+  }
+
+  // the report follows the changes commands make to the session, whatever the values contain
+  if _, err := shell.Execute( "cd /tmp; export STATUS_TEST=$'a b=c\\n'; false", 30*time.Second, Options{} ); err != nil {
+    t.Fatalf( "The command failed: %v", err )
+  }
+  status = shell.Status()
+  if status.WorkingDirectory != "/tmp" || status.Environment["STATUS_TEST"] != "a b=c\n" {
+    t.Errorf( "The status should follow the session, but got %+v.", status )
+  }
+  if status.LastCommandID != 1 || status.LastExitCode != 1 {
+    t.Errorf( "The status should report the last command, but got %+v.", status )
+  }
+
+  // per-command options do not change the session
+  if _, err := shell.Execute( "true", 30*time.Second, Options{ WorkingDirectory: "/" } ); err != nil {
+    t.Fatalf( "The command failed: %v", err )
+  }
+  if status = shell.Status(); status.WorkingDirectory != "/tmp" {
+    t.Errorf( "The working directory should still be /tmp, but got %s.", status.WorkingDirectory )
+  }
+
+  // a running command is reported with its elapsed time
+  if _, err := shell.Execute( "sleep 1", 100*time.Millisecond, Options{} ); err != ErrTimeout {
+    t.Fatalf( "The command should have timed out: %v", err )
+  }
+  status = shell.Status()
+  if status.CommandID != 3 || status.Command != "sleep 1" || status.Elapsed < 100*time.Millisecond {
+    t.Errorf( "The status should report the running command, but got %+v.", status )
+  }
+  if _, err := shell.Wait( context.Background() ); err != nil {
+    t.Fatalf( "The command should complete: %v", err )
+  }
+  if status = shell.Status(); status.CommandID != 0 || status.LastCommandID != 3 {
+    t.Errorf( "The status should report the completed command, but got %+v.", status )
+  }
+}
+
+func TestShellKillCommand( t *testing.T ) {
+  shell := newTestShell( t )
+  defer shell.Unlock()
+
+  if err := shell.Start(); err != nil {
+    t.Fatalf( "The shell failed to start: %v", err )
+  }
+
+  result, err := shell.Execute( "sleep 30", 100*time.Millisecond, Options{} )
+  if err != ErrTimeout || result.ID == 0 {
+    t.Fatalf( "The timed out command should have an ID: %v", err )
+  }
+  waitForeground( t, shell )
+
+  if err := shell.KillCommand( result.ID+1 ); err != ErrNotInHistory {
+    t.Errorf( "An unknown command should not be killed, but got: %v", err )
+  }
+  if err := shell.KillCommand( result.ID ); err != nil {
+    t.Fatalf( "The command failed to kill: %v", err )
+  }
+
+  ctx, cancel := context.WithTimeout( context.Background(), 5*time.Second )
+  defer cancel()
+  completed, err := shell.Follow( ctx, func( string ) {} )
+  if err != nil || completed.ID != result.ID || completed.ExitCode != 130 {
+    t.Fatalf( "The killed command should complete with exit code 130: %+v ( %v )", completed, err )
+  }
+
+  if err := shell.KillCommand( result.ID ); err != ErrCommandFinished {
+    t.Errorf( "A completed command should not be killed, but got: %v", err )
   }
 }
 
@@ -160,7 +789,7 @@ func TestShellRecycle( t *testing.T ) {
     t.Fatalf( "The shell failed to start: %v", err )
   }
 
-  _, err := shell.Execute( "export RECYCLE_TEST=before", 30*time.Second )
+  _, err := shell.Execute( "export RECYCLE_TEST=before", 30*time.Second, Options{} )
   if err != nil {
     t.Fatalf( "The command failed to run: %v", err )
   }
@@ -182,12 +811,12 @@ func TestShellRecycle( t *testing.T ) {
     t.Errorf( "The state should be Ready after restart, but got %s.", shell.State() )
   }
 
-  output, err := shell.Execute( "echo ${RECYCLE_TEST:-unset}", 30*time.Second )
+  result, err := shell.Execute( "echo ${RECYCLE_TEST:-unset}", 30*time.Second, Options{} )
   if err != nil {
     t.Fatalf( "The command failed to run: %v", err )
   }
 
-  output = strings.TrimSpace( output )
+  output := strings.TrimSpace( result.Output )
   if output != "unset" {
     t.Errorf( "The variable should be unset after recycle, but got '%s'.", output )
   }
@@ -217,12 +846,12 @@ func TestShellMultilineOutput( t *testing.T ) {
     t.Fatalf( "The shell failed to start: %v", err )
   }
 
-  output, err := shell.Execute( "echo -e 'line1\\nline2\\nline3'", 30*time.Second )
+  result, err := shell.Execute( "echo -e 'line1\\nline2\\nline3'", 30*time.Second, Options{} )
   if err != nil {
     t.Fatalf( "The command failed to run: %v", err )
   }
 
-  lines := strings.Split( strings.TrimSpace( output ), "\n" )
+  lines := strings.Split( strings.TrimSpace( result.Output ), "\n" )
   if len( lines ) != 3 {
     t.Errorf( "The output should have 3 lines, but got %d: %v", len( lines ), lines )
   }
@@ -237,23 +866,23 @@ func TestShellOutputWithoutTrailingNewline( t *testing.T ) {
   }
 
   // printf without newline should not hang
-  output, err := shell.Execute( "printf 'no_newline'", 5*time.Second )
+  result, err := shell.Execute( "printf 'no_newline'", 5*time.Second, Options{} )
   if err != nil {
     t.Fatalf( "The command failed to run: %v", err )
   }
 
-  output = strings.TrimSpace( output )
+  output := strings.TrimSpace( result.Output )
   if output != "no_newline" {
     t.Errorf( "The output should be 'no_newline', but got '%s'.", output )
   }
 
   // head -c also outputs without trailing newline
-  output, err = shell.Execute( "echo -n 'head_test'", 5*time.Second )
+  result, err = shell.Execute( "echo -n 'head_test'", 5*time.Second, Options{} )
   if err != nil {
     t.Fatalf( "The echo -n command failed to run: %v", err )
   }
 
-  output = strings.TrimSpace( output )
+  output = strings.TrimSpace( result.Output )
   if output != "head_test" {
     t.Errorf( "The output should be 'head_test', but got '%s'.", output )
   }
@@ -268,7 +897,7 @@ func TestShellTimeout( t *testing.T ) {
   }
 
   // run a command with a very short timeout
-  _, err := shell.Execute( "sleep 5", 100*time.Millisecond )
+  _, err := shell.Execute( "sleep 5", 100*time.Millisecond, Options{} )
   if err != ErrTimeout {
     t.Errorf( "The command should have timed out, but got: %v", err )
   }
@@ -286,3 +915,123 @@ func TestShellTimeout( t *testing.T ) {
     t.Errorf( "The state should be Ready after background completion, but got %s.", shell.State() )
   }
 }
+
+func TestShellHistory( t *testing.T ) {
+  logger := slog.New( slog.NewTextHandler( os.Stderr, &slog.HandlerOptions{
+    Level: slog.LevelError,
+  } ) )
+  shell := NewShell( Config{ Command: "/bin/bash", KillGracePeriod: 5*time.Second, HistorySize: 2 }, logger )
+  defer shell.Unlock()
+
+  if err := shell.Start(); err != nil {
+    t.Fatalf( "The shell failed to start: %v", err )
+  }
+
+  for _, command := range []string{ "echo one", "echo two", "echo three; (exit 3)" } {
+    if _, err := shell.Execute( command, 5*time.Second, Options{} ); err != nil {
+      t.Fatalf( "The command '%s' failed to run: %v", command, err )
+    }
+  }
+
+  entries := shell.History()
+  if len( entries ) != 2 || entries[0].Command != "echo two" || entries[1].ID != entries[0].ID+1 {
+    t.Fatalf( "The history should keep the last two commands, but got %+v.", entries )
+  }
+
+  entry, err := shell.HistoryEntry( entries[1].ID )
+  if err != nil {
+    t.Fatalf( "The command should be in the history: %v", err )
+  }
+  if entry.Status != CommandCompleted || entry.Result == nil || entry.Result.ExitCode != 3 ||
+     strings.TrimSpace( entry.Result.Output ) != "three" || entry.Ended.Before( entry.Started ) {
+    t.Errorf( "The history entry should record the result, but got %+v.", entry )
+  }
+
+  if _, err := shell.HistoryEntry( entries[0].ID - 1 ); err != ErrNotInHistory {
+    t.Errorf( "The dropped command should not be in the history, but got: %v", err )
+  }
+}
+
+func TestShellQueue( t *testing.T ) {
+  logger := slog.New( slog.NewTextHandler( os.Stderr, &slog.HandlerOptions{
+    Level: slog.LevelError,
+  } ) )
+  shell := NewShell( Config{ Command: "/bin/bash", KillGracePeriod: 5*time.Second, HistorySize: 10, QueueDepth: 2 }, logger )
+  defer shell.Unlock()
+
+  if err := shell.Start(); err != nil {
+    t.Fatalf( "The shell failed to start: %v", err )
+  }
+
+  if _, err := shell.Submit( "sleep 0.5; echo first", Options{} ); err != nil {
+    t.Fatalf( "The first command failed to submit: %v", err )
+  }
+  cancelled, err := shell.Submit( "echo cancelled", Options{} )
+  if err != nil {
+    t.Fatalf( "The second command should be queued: %v", err )
+  }
+
+  // the queue is full until the second command is cancelled
+  results := make( chan Result, 1 )
+  go func() {
+    result, _ := shell.Execute( "echo third", 5*time.Second, Options{} )
+    results <- result
+  }()
+  time.Sleep( 100 * time.Millisecond )
+  if _, err := shell.Submit( "echo rejected", Options{} ); err == nil {
+    t.Errorf( "A command should be rejected when the queue is full." )
+  }
+  if err := shell.KillCommand( cancelled ); err != nil {
+    t.Errorf( "The queued command should be cancelled: %v", err )
+  }
+
+  result := <-results
+  if strings.TrimSpace( result.Output ) != "third" {
+    t.Errorf( "The queued command should return its own output, but got '%s'.", result.Output )
+  }
+
+  entries := shell.History()
+  if len( entries ) != 3 || entries[0].Status != CommandCompleted ||
+     entries[1].Status != CommandCancelled || entries[2].Status != CommandCompleted {
+    t.Errorf( "The history should record the queued commands in order, but got %+v.", entries )
+  }
+  if entries[2].Started.Before( entries[0].Ended ) {
+    t.Errorf( "The queued command should start after the running command completed." )
+  }
+}
+
+func TestShellWait( t *testing.T ) {
+  shell := newTestShell( t )
+  defer shell.Unlock()
+
+  if err := shell.Start(); err != nil {
+    t.Fatalf( "The shell failed to start: %v", err )
+  }
+
+  if _, err := shell.Wait( context.Background() ); err != ErrNoCommand {
+    t.Errorf( "Waiting without a command should fail, but got: %v", err )
+  }
+
+  id, err := shell.Submit( "sleep 0.5; echo done", Options{} )
+  if err != nil {
+    t.Fatalf( "The command failed to submit: %v", err )
+  }
+
+  ctx, cancel := context.WithTimeout( context.Background(), 100*time.Millisecond )
+  defer cancel()
+  if result, err := shell.Wait( ctx ); err != context.DeadlineExceeded || result.ID != id {
+    t.Errorf( "Waiting should time out with the command ID, but got %+v ( %v ).", result, err )
+  }
+
+  ctx, cancel = context.WithTimeout( context.Background(), 5*time.Second )
+  defer cancel()
+  result, err := shell.Wait( ctx )
+  if err != nil || result.ID != id || strings.TrimSpace( result.Output ) != "done" {
+    t.Errorf( "Waiting should return the result of the command, but got %+v ( %v ).", result, err )
+  }
+
+  // the last result is returned once the command has completed
+  if result, err := shell.Wait( context.Background() ); err != nil || result.ID != id {
+    t.Errorf( "Waiting after completion should return the last result, but got %+v ( %v ).", result, err )
+  }
+}
@@ -0,0 +1,92 @@
+package shell
+
+import (
+  "bytes"
+  "encoding/base64"
+  "fmt"
+  "strings"
+  "time"
+)
+
+// Status is a snapshot of the shell session; the working directory and variables are reported by
+// the shell after every command, so they describe the session as the last command left it. The
+// command fields are only set while a command is running and the last command ID is zero until a
+// command completes. The limits usage is only set when the shell runs in a cgroup
+type Status struct {
+  State            State
+  PID              int
+  WorkingDirectory string
+  Environment      map[string]string
+  CommandID        uint64
+  Command          string
+  Elapsed          time.Duration
+  LastCommandID    uint64
+  LastExitCode     int
+  Restarts         int
+  Limits           *LimitsUsage
+}
+
+// Status returns a snapshot of the shell session
+func ( shell *Shell ) Status() Status {
+  shell.mu.Lock()
+  defer shell.mu.Unlock()
+
+  status := Status{
+    State:            shell.state,
+    WorkingDirectory: shell.directory,
+    Environment:      make( map[string]string, len( shell.variables ) ),
+    Restarts:         shell.restarts,
+  }
+  for name, value := range shell.variables {
+    status.Environment[name] = value
+  }
+  if shell.cmd != nil && shell.cmd.Process != nil {
+    status.PID = shell.cmd.Process.Pid
+  }
+  if shell.state == StateExecuting {
+    status.CommandID = shell.commandID
+    status.Command = shell.currentCommand
+    status.Elapsed = time.Since( shell.commandStarted )
+  }
+  if shell.cgroup != nil {
+    usage := shell.cgroup.usage()
+    status.Limits = &usage
+  }
+  if shell.lastResult != nil {
+    status.LastCommandID = shell.lastResult.ID
+    status.LastExitCode = shell.lastResult.ExitCode
+  }
+  return status
+}
+
+// reportCommand returns the shell command that prints the working directory followed by the
+// reported variables that are set, NUL separated and base64 encoded so the report is a single
+// line whatever the values contain
+func ( shell *Shell ) reportCommand() string {
+  var command strings.Builder
+  command.WriteString( `printf '%s\0' "$PWD"` )
+  for _, name := range shell.stateVariables {
+    fmt.Fprintf( &command, ` "${%s+%s=$%s}"`, name, name, name )
+  }
+  command.WriteString( `|base64|tr -d '\n'` )
+  return command.String()
+}
+
+// readReport records the working directory and variables from a report printed by the shell; a
+// report that cannot be decoded is ignored. The caller holds the lock
+func ( shell *Shell ) readReport( encoded []byte ) {
+  report, err := base64.StdEncoding.DecodeString( string( encoded ) )
+  if err != nil || len( report ) == 0 {
+    return
+  }
+
+  fields := bytes.Split( bytes.TrimSuffix( report, []byte{ 0 } ), []byte{ 0 } )
+  shell.directory = string( fields[0] )
+  shell.variables = make( map[string]string, len( fields )-1 )
+  for _, field := range fields[1:] {
+    // a variable that is not set is reported as an empty field
+    if name, value, ok := strings.Cut( string( field ), "=" ); ok {
+      shell.variables[name] = value
+    }
+  }
+}
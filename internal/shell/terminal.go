@@ -0,0 +1,201 @@
+package shell
+
+import (
+  "errors"
+  "fmt"
+  "os"
+  "sync"
+  "time"
+
+  "github.com/creack/pty"
+)
+
+// terminalBufferSize is the number of output chunks buffered for an attached terminal before
+// further chunks are dropped
+const terminalBufferSize = 256
+
+// Terminal is a client attached directly to the shell PTY for manual use
+type Terminal struct {
+  shell      *Shell
+  output     chan []byte
+  outputMu   sync.Mutex
+  closed     bool
+  readerDone chan struct{}
+}
+
+// Attach connects a terminal to the shell PTY
+//
+// attaching to a waiting shell moves it to StateAttached, which rejects commands until the terminal
+// detaches; attaching while a command is executing mirrors its output and lets the terminal answer
+// prompts, and the shell moves to StateAttached once the command completes
+func ( shell *Shell ) Attach() ( *Terminal, error ) {
+  shell.mu.Lock()
+  defer shell.mu.Unlock()
+
+  if shell.state != StateLocked && shell.state != StateExecuting {
+    return nil, fmt.Errorf( "The terminal cannot be attached in state %s.", shell.state )
+  }
+  if shell.terminal != nil {
+    return nil, fmt.Errorf( "A terminal is already attached to the shell." )
+  }
+
+  shell.logger.Info( "Shell | Attach | A terminal has been attached.", "state", shell.state )
+
+  terminal := &Terminal{
+    shell:  shell,
+    output: make( chan []byte, terminalBufferSize ),
+  }
+  shell.terminal = terminal
+
+  if shell.state == StateLocked {
+    shell.startTerminalReader()
+  }
+  return terminal, nil
+}
+
+// Output returns the channel receiving the PTY output; it is closed when the terminal is detached
+func ( terminal *Terminal ) Output() <-chan []byte {
+  return terminal.output
+}
+
+// Write sends input to the PTY
+func ( terminal *Terminal ) Write( data []byte ) error {
+  terminal.shell.mu.Lock()
+  defer terminal.shell.mu.Unlock()
+
+  if terminal.shell.terminal != terminal || terminal.shell.ptyFile == nil {
+    return fmt.Errorf( "The terminal is no longer attached." )
+  }
+  if _, err := terminal.shell.ptyFile.Write( data ); err != nil {
+    return fmt.Errorf( "The input could not be written to the shell: %w", err )
+  }
+  return nil
+}
+
+// Resize changes the window size of the PTY
+func ( terminal *Terminal ) Resize( rows uint16, cols uint16 ) error {
+  terminal.shell.mu.Lock()
+  defer terminal.shell.mu.Unlock()
+
+  if terminal.shell.terminal != terminal || terminal.shell.ptyFile == nil {
+    return fmt.Errorf( "The terminal is no longer attached." )
+  }
+  if err := pty.Setsize( terminal.shell.ptyFile, &pty.Winsize{ Rows: rows, Cols: cols } ); err != nil {
+    return fmt.Errorf( "The terminal could not be resized: %w", err )
+  }
+  return nil
+}
+
+// Detach disconnects the terminal and returns the shell to StateLocked
+func ( terminal *Terminal ) Detach() {
+  shell := terminal.shell
+
+  shell.mu.Lock()
+  if shell.terminal != terminal {
+    shell.mu.Unlock()
+    return
+  }
+  shell.terminal = nil
+
+  readerDone := terminal.readerDone
+  ptyFile := shell.ptyFile
+  if shell.state == StateAttached && ptyFile != nil {
+    // interrupt the terminal reader so the PTY can be handed back to command execution
+    ptyFile.SetReadDeadline( time.Now() )
+  }
+  shell.mu.Unlock()
+
+  if readerDone != nil {
+    <-readerDone
+  }
+
+  shell.mu.Lock()
+  if shell.state == StateAttached && shell.ptyFile == ptyFile {
+    ptyFile.SetReadDeadline( time.Time{} )
+    // discard anything left typed at the prompt ( Ctrl+U ) so it does not prefix the next command;
+    // Ctrl+C is not used because the interrupt flushes input that is written right after it
+    ptyFile.Write( []byte{ 0x15 } )
+    shell.state = StateLocked
+    shell.notifyOutput()
+    shell.runQueued()
+  }
+  shell.mu.Unlock()
+
+  terminal.close()
+  shell.logger.Info( "Shell | Detach | The terminal has been detached." )
+}
+
+// deliver passes PTY output to the terminal without blocking the reader
+func ( terminal *Terminal ) deliver( data []byte ) {
+  terminal.outputMu.Lock()
+  defer terminal.outputMu.Unlock()
+
+  if terminal.closed {
+    return
+  }
+
+  chunk := make( []byte, len( data ) )
+  copy( chunk, data )
+
+  select {
+  case terminal.output <- chunk:
+  default:
+    terminal.shell.logger.Debug( "Shell | Deliver | The terminal is not keeping up, output dropped." )
+  }
+}
+
+// close closes the output channel once
+func ( terminal *Terminal ) close() {
+  terminal.outputMu.Lock()
+  defer terminal.outputMu.Unlock()
+
+  if !terminal.closed {
+    terminal.closed = true
+    close( terminal.output )
+  }
+}
+
+// startTerminalReader moves the shell to StateAttached and starts reading the PTY for the terminal;
+// the caller holds the lock
+func ( shell *Shell ) startTerminalReader() {
+  shell.state = StateAttached
+  shell.terminal.readerDone = make( chan struct{} )
+  shell.notifyOutput()
+  go shell.readTerminal( shell.ptyFile, shell.terminal )
+}
+
+// readTerminal reads from the PTY on behalf of an attached terminal until it is detached
+func ( shell *Shell ) readTerminal( ptyFile *os.File, terminal *Terminal ) {
+  defer close( terminal.readerDone )
+  buf := make( []byte, 4096 )
+
+  for {
+    bytesRead, err := ptyFile.Read( buf )
+    if bytesRead > 0 {
+      terminal.deliver( buf[:bytesRead] )
+    }
+    if err != nil {
+      if errors.Is( err, os.ErrDeadlineExceeded ) {
+        return
+      }
+
+      shell.mu.Lock()
+      // a shell that was unlocked while the terminal was attached has not failed
+      if shell.ptyFile == ptyFile && shell.state == StateAttached {
+        shell.logger.Error( "Shell | ReadTerminal | The shell read failed.", "error", err )
+        shell.state = StateUnrecoverable
+        if shell.restartPolicy.Automatic {
+          shell.terminal = nil
+          shell.restart( 0, err )
+        }
+        shell.notifyOutput()
+      }
+      if shell.terminal == terminal {
+        shell.terminal = nil
+      }
+      shell.mu.Unlock()
+      terminal.close()
+      return
+    }
+  }
+}
@@ -0,0 +1,115 @@
+package shell
+
+import (
+  "strings"
+  "testing"
+  "time"
+)
+
+// readTerminalUntil collects terminal output until it contains the text or the timeout elapses
+func readTerminalUntil( terminal *Terminal, text string, timeout time.Duration ) string {
+  var output strings.Builder
+  deadline := time.After( timeout )
+
+  for !strings.Contains( output.String(), text ) {
+    select {
+    case chunk, ok := <-terminal.Output():
+      if !ok {
+        return output.String()
+      }
+      output.Write( chunk )
+    case <-deadline:
+      return output.String()
+    }
+  }
+  return output.String()
+}
+
+func TestShellAttach( t *testing.T ) {
+  shell := newTestShell( t )
+  defer shell.Unlock()
+
+  if err := shell.Start(); err != nil {
+    t.Fatalf( "The shell failed to start: %v", err )
+  }
+
+  terminal, err := shell.Attach()
+  if err != nil {
+    t.Fatalf( "The terminal failed to attach: %v", err )
+  }
+
+  if shell.State() != StateAttached {
+    t.Errorf( "The state should be Attached, but got %s.", shell.State() )
+  }
+  if _, err := shell.Attach(); err == nil {
+    t.Error( "A second terminal should not be able to attach." )
+  }
+  if _, err := shell.Execute( "echo hello", 5*time.Second, Options{} ); err == nil {
+    t.Error( "Commands should be rejected while a terminal is attached." )
+  }
+
+  if err := terminal.Write( []byte( "export ATTACH_TEST=typed; echo $((6*7))\n" ) ); err != nil {
+    t.Fatalf( "The input failed to write: %v", err )
+  }
+  if output := readTerminalUntil( terminal, "42", 5*time.Second ); !strings.Contains( output, "42" ) {
+    t.Errorf( "The terminal should show the command output, but got '%s'.", output )
+  }
+
+  terminal.Detach()
+
+  if shell.State() != StateLocked {
+    t.Errorf( "The state should be Ready after detach, but got %s.", shell.State() )
+  }
+
+  // state set from the terminal is part of the session
+  result, err := shell.Execute( "echo $ATTACH_TEST", 5*time.Second, Options{} )
+  if err != nil {
+    t.Fatalf( "The command failed to run after detach: %v", err )
+  }
+  if result.Output != "typed" {
+    t.Errorf( "The output should be 'typed', but got '%s'.", result.Output )
+  }
+}
+
+func TestShellAttachWhileExecuting( t *testing.T ) {
+  shell := newTestShell( t )
+  defer shell.Unlock()
+
+  if err := shell.Start(); err != nil {
+    t.Fatalf( "The shell failed to start: %v", err )
+  }
+
+  if _, err := shell.Submit( "read answer; echo \"answer is $answer\"", Options{} ); err != nil {
+    t.Fatalf( "The command failed to submit: %v", err )
+  }
+
+  terminal, err := shell.Attach()
+  if err != nil {
+    t.Fatalf( "The terminal failed to attach: %v", err )
+  }
+  if shell.State() != StateExecuting {
+    t.Errorf( "The state should stay Executing while the command runs, but got %s.", shell.State() )
+  }
+
+  if err := terminal.Write( []byte( "yes\n" ) ); err != nil {
+    t.Fatalf( "The input failed to write: %v", err )
+  }
+  readTerminalUntil( terminal, "answer is yes", 5*time.Second )
+
+  deadline := time.Now().Add( 5 * time.Second )
+  for shell.State() != StateAttached && time.Now().Before( deadline ) {
+    time.Sleep( 50 * time.Millisecond )
+  }
+  if shell.State() != StateAttached {
+    t.Errorf( "The terminal should take over once the command completes, but got %s.", shell.State() )
+  }
+  if last := shell.Output(); last == nil || last.Output != "answer is yes" {
+    t.Errorf( "The command result should be recorded, but got %v.", last )
+  }
+
+  terminal.Detach()
+
+  if shell.State() != StateLocked {
+    t.Errorf( "The state should be Ready after detach, but got %s.", shell.State() )
+  }
+}
@@ -0,0 +1,71 @@
+package shell
+
+import (
+  "sort"
+  "time"
+)
+
+// ProcessInfo describes a process started by the shell; the command is the process name in
+// brackets when its command line cannot be read, as for a zombie
+type ProcessInfo struct {
+  PID          int
+  ParentID     int
+  Command      string
+  State        string
+  CPUTime      time.Duration
+  ResidentSize int64
+}
+
+// ProcessTree returns the descendants of the shell process, depth first with the children of a
+// process ordered by PID
+func ( shell *Shell ) ProcessTree() ( int, []ProcessInfo, error ) {
+  shell.mu.Lock()
+  if shell.cmd == nil || shell.cmd.Process == nil || shell.state == StateAvailable ||
+     shell.state == StateUnrecoverable {
+    shell.mu.Unlock()
+    return 0, nil, ErrShellNotRunning
+  }
+  shellPid := shell.cmd.Process.Pid
+  shell.mu.Unlock()
+
+  pids, err := processIDs()
+  if err != nil {
+    return 0, nil, err
+  }
+
+  // processes may exit while /proc is walked, so the ones that cannot be read are left out
+  stats := make( map[int]processStat, len( pids ) )
+  children := make( map[int][]int )
+  for _, pid := range pids {
+    stat, err := readProcessStat( pid )
+    if err != nil {
+      continue
+    }
+    stats[pid] = stat
+    children[stat.ParentID] = append( children[stat.ParentID], pid )
+  }
+
+  var tree []ProcessInfo
+  var visit func( parent int )
+  visit = func( parent int ) {
+    sort.Ints( children[parent] )
+    for _, pid := range children[parent] {
+      stat := stats[pid]
+      command := readCommandLine( pid )
+      if command == "" {
+        command = "[" + stat.Name + "]"
+      }
+      tree = append( tree, ProcessInfo{
+        PID:          pid,
+        ParentID:     stat.ParentID,
+        Command:      command,
+        State:        stat.State,
+        CPUTime:      stat.CPUTime,
+        ResidentSize: stat.ResidentSize,
+      } )
+      visit( pid )
+    }
+  }
+  visit( shellPid )
+  return shellPid, tree, nil
+}
@@ -0,0 +1,80 @@
+package shell
+
+import (
+  "errors"
+  "os"
+  "strings"
+  "testing"
+  "time"
+)
+
+func TestShellProcessTree( t *testing.T ) {
+  shell := newTestShell( t )
+  defer shell.Unlock()
+
+  if _, _, err := shell.ProcessTree(); !errors.Is( err, ErrShellNotRunning ) {
+    t.Errorf( "The tree should not be read before the shell starts: %v", err )
+  }
+  if err := shell.Start(); err != nil {
+    t.Fatalf( "The shell failed to start: %v", err )
+  }
+
+  if _, err := shell.Execute( "bash -c 'sleep 30; true' | cat", 100*time.Millisecond, Options{} ); err != ErrTimeout {
+    t.Fatalf( "The command should time out: %v", err )
+  }
+
+  // the sleep is a grandchild of the shell and comes right after the bash that started it
+  var shellPid, sleeping int
+  var tree []ProcessInfo
+  for attempt := 0; attempt < 100; attempt++ {
+    var err error
+    shellPid, tree, err = shell.ProcessTree()
+    if err != nil {
+      t.Fatalf( "The tree failed to read: %v", err )
+    }
+    sleeping = -1
+    for index, process := range tree {
+      if process.Command == "sleep 30" {
+        sleeping = index
+      }
+    }
+    if sleeping >= 0 {
+      break
+    }
+    time.Sleep( 50 * time.Millisecond )
+  }
+  if shellPid != shell.Status().PID {
+    t.Errorf( "The tree should start at the shell %d, but got %d.", shell.Status().PID, shellPid )
+  }
+  if sleeping < 1 || tree[sleeping-1].Command != "bash -c sleep 30; true" ||
+     tree[sleeping].ParentID != tree[sleeping-1].PID || tree[sleeping-1].ParentID != shellPid {
+    t.Fatalf( "The sleep should be listed under its bash, but got %+v.", tree )
+  }
+  if tree[sleeping].State != "S" || tree[sleeping].ResidentSize <= 0 {
+    t.Errorf( "The sleep should be sleeping with resident memory, but got %+v.", tree[sleeping] )
+  }
+
+  // every process hangs off the shell or a process listed before it
+  listed := map[int]bool{ shellPid: true }
+  for _, process := range tree {
+    if !listed[process.ParentID] {
+      t.Errorf( "The process %+v is not listed under its parent.", process )
+    }
+    listed[process.PID] = true
+  }
+
+  shell.Kill()
+}
+
+func TestReadProcessStat( t *testing.T ) {
+  stat, err := readProcessStat( os.Getpid() )
+  if err != nil {
+    t.Fatalf( "The stat failed to read: %v", err )
+  }
+  if stat.ParentID != os.Getppid() || stat.ResidentSize <= 0 || stat.Name == "" {
+    t.Errorf( "The stat should describe the test process, but got %+v.", stat )
+  }
+  if command := readCommandLine( os.Getpid() ); !strings.HasPrefix( command, os.Args[0] ) {
+    t.Errorf( "The command line should start with %s, but got '%s'.", os.Args[0], command )
+  }
+}
@@ -0,0 +1,58 @@
+#!/bin/bash
+# test detecting that a running command is waiting for input
+
+BASE_URL="http://localhost:8084"
+API_KEY="test"
+
+# startup
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/lock"
+
+# a busy command is not waiting for input
+response=$(curl -s -X POST \
+  -H "X-Shell-Key: $API_KEY" \
+  -H "Content-Type: application/json" \
+  -d '{"command":"sleep 30","timeout":"1s"}' \
+  "$BASE_URL/execute")
+if [[ "$response" != *'"awaiting_input":false'* ]]; then
+  echo "a sleeping command should not be awaiting input: got '$response'"
+  exit 1
+fi
+
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/kill"
+sleep 1
+
+# a command at a prompt is waiting for input
+response=$(curl -s -X POST \
+  -H "X-Shell-Key: $API_KEY" \
+  -H "Content-Type: application/json" \
+  -d '{"command":"read -p \"Proceed? [y/N] \" answer; echo \"answer=$answer\"","timeout":"1s"}' \
+  "$BASE_URL/execute")
+if [[ "$response" != *'"awaiting_input":true'* ]]; then
+  echo "a command at a prompt should be awaiting input: got '$response'"
+  exit 1
+fi
+
+response=$(curl -s -H "X-Shell-Key: $API_KEY" -H "Accept: application/json" "$BASE_URL/state")
+if [[ "$response" != *'"awaiting_input":true'* ]]; then
+  echo "state should report awaiting input: got '$response'"
+  exit 1
+fi
+
+# plain text state reports it in a header
+header=$(curl -s -D - -o /dev/null -H "X-Shell-Key: $API_KEY" "$BASE_URL/state" | tr -d '\r' | grep -i "^X-Awaiting-Input:")
+if [ "$header" != "X-Awaiting-Input: true" ]; then
+  echo "state should have the awaiting input header: got '$header'"
+  exit 1
+fi
+
+# answering the prompt completes the command
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" -d "y" "$BASE_URL/input?newline=true"
+sleep 1
+
+response=$(curl -s -H "X-Shell-Key: $API_KEY" -H "Accept: application/json" "$BASE_URL/state")
+if [[ "$response" != *'"state":"locked","awaiting_input":false'* ]]; then
+  echo "state should be locked after the answer: got '$response'"
+  exit 1
+fi
+
+exit 0
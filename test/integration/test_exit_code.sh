@@ -0,0 +1,42 @@
+#!/bin/bash
+# test command exit code reporting
+
+BASE_URL="http://localhost:8084"
+API_KEY="test"
+
+# startup
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/lock"
+
+# successful command reports exit code 0
+exit_code=$(curl -s -o /dev/null -D - -X POST -H "X-Shell-Key: $API_KEY" -d "true" "$BASE_URL/execute" \
+  | grep -i '^X-Exit-Code:' | awk '{print $2}' | tr -d '\r')
+if [ "$exit_code" != "0" ]; then
+  echo "successful command should report exit code 0: got '$exit_code'"
+  exit 1
+fi
+
+# failing command reports its exit code
+exit_code=$(curl -s -o /dev/null -D - -X POST -H "X-Shell-Key: $API_KEY" -d "(exit 7)" "$BASE_URL/execute" \
+  | grep -i '^X-Exit-Code:' | awk '{print $2}' | tr -d '\r')
+if [ "$exit_code" != "7" ]; then
+  echo "failing command should report exit code 7: got '$exit_code'"
+  exit 1
+fi
+
+# command that finishes after a timeout reports its exit code through /output
+curl -s -o /dev/null -X POST \
+  -H "X-Shell-Key: $API_KEY" \
+  -H "X-Command-Timeout: 500ms" \
+  -d "sleep 2; (exit 4)" \
+  "$BASE_URL/execute"
+
+sleep 3
+
+exit_code=$(curl -s -o /dev/null -D - -H "X-Shell-Key: $API_KEY" "$BASE_URL/output" \
+  | grep -i '^X-Exit-Code:' | awk '{print $2}' | tr -d '\r')
+if [ "$exit_code" != "4" ]; then
+  echo "output should report exit code 4: got '$exit_code'"
+  exit 1
+fi
+
+exit 0
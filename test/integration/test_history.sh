@@ -0,0 +1,49 @@
+#!/bin/bash
+# test the command history
+
+BASE_URL="http://localhost:8084"
+API_KEY="test"
+
+# startup
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/lock"
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" -d "echo first" "$BASE_URL/execute"
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" -d "echo second; false" "$BASE_URL/execute"
+
+# the history lists the commands oldest first without their output
+response=$(curl -s -H "X-Shell-Key: $API_KEY" "$BASE_URL/history")
+if [[ "$response" != '[{"id":1,"command":"echo first","status":"completed"'* ]]; then
+  echo "history should start with the first command: got '$response'"
+  exit 1
+fi
+if [[ "$response" != *'{"id":2,"command":"echo second; false","status":"completed"'*'"exit_code":1'* ]]; then
+  echo "history should record the second command and its exit code: got '$response'"
+  exit 1
+fi
+if [[ "$response" == *'"output"'* ]]; then
+  echo "history list should not include output: got '$response'"
+  exit 1
+fi
+
+# a single command includes its output
+response=$(curl -s -H "X-Shell-Key: $API_KEY" "$BASE_URL/history/1")
+if [[ "$response" != *'"exit_code":0'* ]] || [[ "$response" != *'"output":"first"'* ]]; then
+  echo "history entry should include the output: got '$response'"
+  exit 1
+fi
+
+# a running command is listed as running
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" -H "X-Command-Timeout: 100ms" -d "sleep 1" "$BASE_URL/execute"
+response=$(curl -s -H "X-Shell-Key: $API_KEY" "$BASE_URL/history/3")
+if [[ "$response" != *'"status":"running"'* ]] || [[ "$response" != *'"exit_code":null'* ]]; then
+  echo "running command should be listed as running: got '$response'"
+  exit 1
+fi
+
+# an unknown command is not found
+status=$(curl -s -o /dev/null -w "%{http_code}" -H "X-Shell-Key: $API_KEY" "$BASE_URL/history/99")
+if [ "$status" != "404" ]; then
+  echo "unknown command should return 404: got $status"
+  exit 1
+fi
+
+exit 0
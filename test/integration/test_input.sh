@@ -0,0 +1,67 @@
+#!/bin/bash
+# test sending input to a running command
+
+BASE_URL="http://localhost:8084"
+API_KEY="test"
+
+# startup
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/lock"
+
+# input without a running command is rejected
+status=$(curl -s -o /dev/null -w "%{http_code}" -X POST -H "X-Shell-Key: $API_KEY" -d "y" "$BASE_URL/input?newline=true")
+if [ "$status" != "409" ]; then
+  echo "input without a running command should return 409: got $status"
+  exit 1
+fi
+
+# flags are booleans
+status=$(curl -s -o /dev/null -w "%{http_code}" -X POST -H "X-Shell-Key: $API_KEY" -d "y" "$BASE_URL/input?eof=maybe")
+if [ "$status" != "400" ]; then
+  echo "an invalid flag should return 400: got $status"
+  exit 1
+fi
+
+# a command waiting for input times out
+curl -s -o /dev/null -X POST \
+  -H "X-Shell-Key: $API_KEY" \
+  -H "X-Command-Timeout: 500ms" \
+  -d 'read -p "Proceed? [y/N] " answer; echo "answer=$answer"' \
+  "$BASE_URL/execute"
+
+# the prompt is answered
+status=$(curl -s -o /dev/null -w "%{http_code}" -X POST -H "X-Shell-Key: $API_KEY" -d "y" "$BASE_URL/input?newline=1")
+if [ "$status" != "200" ]; then
+  echo "input should return 200: got $status"
+  exit 1
+fi
+
+sleep 1
+
+response=$(curl -s -H "X-Shell-Key: $API_KEY" "$BASE_URL/output")
+if [[ "$response" != *"answer=y" ]]; then
+  echo "command should receive the answer: got '$response'"
+  exit 1
+fi
+
+# end of file ends a command reading stdin
+curl -s -o /dev/null -X POST \
+  -H "X-Shell-Key: $API_KEY" \
+  -H "X-Command-Timeout: 500ms" \
+  -d 'wc -l' \
+  "$BASE_URL/execute"
+
+curl -s -o /dev/null -X POST \
+  -H "X-Shell-Key: $API_KEY" \
+  -H "Content-Type: application/json" \
+  -d '{"data":"one\ntwo","newline":true,"eof":true}' \
+  "$BASE_URL/input"
+
+sleep 1
+
+response=$(curl -s -H "X-Shell-Key: $API_KEY" "$BASE_URL/output")
+if [[ "$response" != *"2" ]]; then
+  echo "command should count two lines: got '$response'"
+  exit 1
+fi
+
+exit 0
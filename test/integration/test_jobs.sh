@@ -0,0 +1,74 @@
+#!/bin/bash
+# test asynchronous commands and jobs
+
+BASE_URL="http://localhost:8084"
+API_KEY="test"
+
+# startup
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/lock"
+
+# a synchronous command reports its job
+header=$(curl -s -D - -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" -d "echo sync" "$BASE_URL/execute" | tr -d '\r' | grep -i "^X-Job-ID:" | cut -d' ' -f2)
+if [ "$header" != "1" ]; then
+  echo "command should have job ID 1: got '$header'"
+  exit 1
+fi
+
+# an asynchronous command returns at once with its job
+response=$(curl -s -w " %{http_code}" -X POST -H "X-Shell-Key: $API_KEY" -d "sleep 0.5; echo async" "$BASE_URL/execute?async=true")
+if [ "$response" != $'2\n 202' ]; then
+  echo "async command should return 202 with job ID 2: got '$response'"
+  exit 1
+fi
+
+response=$(curl -s -H "X-Shell-Key: $API_KEY" "$BASE_URL/jobs/2")
+if [[ "$response" != *'"status":"running"'* ]]; then
+  echo "job should be running: got '$response'"
+  exit 1
+fi
+
+sleep 1
+response=$(curl -s -H "X-Shell-Key: $API_KEY" "$BASE_URL/jobs/2")
+if [[ "$response" != *'"status":"completed"'* ]] || [[ "$response" != *'"exit_code":0'* ]] || [[ "$response" != *'"output":"async"'* ]]; then
+  echo "job should be completed with its output: got '$response'"
+  exit 1
+fi
+
+# a completed job cannot be killed
+status=$(curl -s -o /dev/null -w "%{http_code}" -X DELETE -H "X-Shell-Key: $API_KEY" "$BASE_URL/jobs/2")
+if [ "$status" != "409" ]; then
+  echo "completed job should not be killed: got $status"
+  exit 1
+fi
+
+# a running job is interrupted
+response=$(curl -s -X POST -H "X-Shell-Key: $API_KEY" -H "Content-Type: application/json" \
+  -d '{"command":"sleep 30"}' "$BASE_URL/execute?async=true")
+if [[ "$response" != '{"job_id":3,'* ]]; then
+  echo "JSON async command should return its job: got '$response'"
+  exit 1
+fi
+sleep 0.5
+status=$(curl -s -o /dev/null -w "%{http_code}" -X DELETE -H "X-Shell-Key: $API_KEY" "$BASE_URL/jobs/3")
+if [ "$status" != "200" ]; then
+  echo "running job should be killed: got $status"
+  exit 1
+fi
+for i in $(seq 50); do
+  response=$(curl -s -H "X-Shell-Key: $API_KEY" "$BASE_URL/jobs/3")
+  [[ "$response" == *'"status":"completed"'* ]] && break
+  sleep 0.1
+done
+if [[ "$response" != *'"exit_code":130'* ]]; then
+  echo "killed job should have exit code 130: got '$response'"
+  exit 1
+fi
+
+# an unknown job is not found
+status=$(curl -s -o /dev/null -w "%{http_code}" -H "X-Shell-Key: $API_KEY" "$BASE_URL/jobs/99")
+if [ "$status" != "404" ]; then
+  echo "unknown job should return 404: got $status"
+  exit 1
+fi
+
+exit 0
@@ -0,0 +1,85 @@
+#!/bin/bash
+# test JSON request and response mode
+
+BASE_URL="http://localhost:8084"
+API_KEY="test"
+
+# startup
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/lock"
+
+# JSON request returns a JSON response with output and exit code
+response=$(curl -s -X POST \
+  -H "X-Shell-Key: $API_KEY" \
+  -H "Content-Type: application/json" \
+  -d '{"command":"echo $GREETING; exit_with() { return $1; }; exit_with 2","env":{"GREETING":"hello"}}' \
+  "$BASE_URL/execute")
+if ! echo "$response" | grep -q '"output":"hello"'; then
+  echo "JSON response should contain output: got '$response'"
+  exit 1
+fi
+if ! echo "$response" | grep -q '"exit_code":2'; then
+  echo "JSON response should contain exit code: got '$response'"
+  exit 1
+fi
+if ! echo "$response" | grep -q '"state":"locked"'; then
+  echo "JSON response should contain state: got '$response'"
+  exit 1
+fi
+
+# cwd applies to the command only
+response=$(curl -s -X POST \
+  -H "X-Shell-Key: $API_KEY" \
+  -H "Content-Type: application/json" \
+  -d '{"command":"pwd","cwd":"/tmp"}' \
+  "$BASE_URL/execute")
+if ! echo "$response" | grep -q '"output":"/tmp"'; then
+  echo "JSON response should reflect cwd: got '$response'"
+  exit 1
+fi
+
+# stderr can be returned separately from stdout
+response=$(curl -s -X POST \
+  -H "X-Shell-Key: $API_KEY" \
+  -H "Content-Type: application/json" \
+  -d '{"command":"echo out; echo err >&2","separate_stderr":true}' \
+  "$BASE_URL/execute")
+if ! echo "$response" | grep -q '"output":"out"' || ! echo "$response" | grep -q '"stderr":"err"'; then
+  echo "JSON response should separate stderr: got '$response'"
+  exit 1
+fi
+
+# errors carry a machine readable code
+response=$(curl -s -X POST \
+  -H "X-Shell-Key: $API_KEY" \
+  -H "Content-Type: application/json" \
+  -d '{"command":""}' \
+  "$BASE_URL/execute")
+if ! echo "$response" | grep -q '"error":"empty_command"'; then
+  echo "empty command should return error code: got '$response'"
+  exit 1
+fi
+
+response=$(curl -s -X POST \
+  -H "X-Shell-Key: wrong" \
+  -H "Accept: application/json" \
+  -d "echo test" \
+  "$BASE_URL/execute")
+if ! echo "$response" | grep -q '"error":"unauthorized"'; then
+  echo "wrong key should return error code: got '$response'"
+  exit 1
+fi
+
+# timeout returns 202 with the executing state
+status=$(curl -s -o /tmp/shelld_json_timeout.txt -w "%{http_code}" -X POST \
+  -H "X-Shell-Key: $API_KEY" \
+  -H "Content-Type: application/json" \
+  -d '{"command":"sleep 2","timeout":"500ms"}' \
+  "$BASE_URL/execute")
+response=$(cat /tmp/shelld_json_timeout.txt)
+rm -f /tmp/shelld_json_timeout.txt
+if [ "$status" != "202" ] || ! echo "$response" | grep -q '"error":"timeout"'; then
+  echo "timeout should return 202 with error code: got $status '$response'"
+  exit 1
+fi
+
+exit 0
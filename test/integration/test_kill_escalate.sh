@@ -0,0 +1,54 @@
+#!/bin/bash
+# test escalating kill
+# a command that ignores SIGINT is stopped with SIGTERM and the shell keeps running
+
+BASE_URL="http://localhost:8084"
+API_KEY="test"
+
+# startup
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/lock"
+
+# nothing to kill
+status=$(curl -s -o /dev/null -w "%{http_code}" -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/kill?escalate=true")
+if [ "$status" != "409" ]; then
+  echo "escalating kill without a command should return 409: got $status"
+  exit 1
+fi
+
+status=$(curl -s -o /dev/null -w "%{http_code}" -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/kill?escalate=maybe")
+if [ "$status" != "400" ]; then
+  echo "invalid escalate parameter should return 400: got $status"
+  exit 1
+fi
+
+# a command that stops on Ctrl+C
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" -H "X-Command-Timeout: 1s" -d "sleep 30" "$BASE_URL/execute"
+header=$(curl -s -D - -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/kill?escalate=true" | tr -d '\r' | grep -i "^X-Kill-Signal:" | cut -d' ' -f2)
+if [ "$header" != "SIGINT" ]; then
+  echo "sleep should be stopped by SIGINT: got '$header'"
+  exit 1
+fi
+
+# a command that ignores SIGINT
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" -H "X-Command-Timeout: 1s" \
+  -d "bash -c \"trap '' INT; sleep 30\"" "$BASE_URL/execute"
+response=$(curl -s -X POST -H "X-Shell-Key: $API_KEY" -H "Accept: application/json" "$BASE_URL/kill?escalate=true")
+if [ "$response" != '{"signal":"SIGTERM","state":"locked"}' ]; then
+  echo "command ignoring SIGINT should be stopped by SIGTERM: got '$response'"
+  exit 1
+fi
+
+header=$(curl -s -D - -o /dev/null -H "X-Shell-Key: $API_KEY" "$BASE_URL/output" | tr -d '\r' | grep -i "^X-Exit-Code:" | cut -d' ' -f2)
+if [ "$header" != "143" ]; then
+  echo "terminated command should have exit code 143: got '$header'"
+  exit 1
+fi
+
+# shell should still work after the kill
+response=$(curl -s -X POST -H "X-Shell-Key: $API_KEY" -d "echo still_alive" "$BASE_URL/execute")
+if [ "$response" != "still_alive" ]; then
+  echo "shell should work after kill: expected 'still_alive', got '$response'"
+  exit 1
+fi
+
+exit 0
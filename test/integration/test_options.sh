@@ -0,0 +1,54 @@
+#!/bin/bash
+# test per-command environment variables and working directory
+# they apply to the command only and leave the session untouched
+
+BASE_URL="http://localhost:8084"
+API_KEY="test"
+
+# startup
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/lock"
+start_dir=$(curl -s -X POST -H "X-Shell-Key: $API_KEY" -d "pwd" "$BASE_URL/execute")
+
+# variables and working directory are passed as headers
+response=$(curl -s -X POST -H "X-Shell-Key: $API_KEY" -H "X-Command-Env: GREETING=hello world" \
+  -H "X-Command-Env: QUERY=a=b" -H "X-Command-Cwd: /tmp" \
+  -d 'echo "$GREETING|$QUERY|$PWD"' "$BASE_URL/execute")
+if [ "$response" != "hello world|a=b|/tmp" ]; then
+  echo "command should see the options: got '$response'"
+  exit 1
+fi
+
+# a directory change made by the command stays in its scope
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" -H "X-Command-Cwd: /tmp" \
+  -d "cd / && export LEAKED=1" "$BASE_URL/execute"
+
+response=$(curl -s -X POST -H "X-Shell-Key: $API_KEY" -d 'echo "${GREETING:-unset}|${LEAKED:-unset}|$PWD"' "$BASE_URL/execute")
+if [ "$response" != "unset|unset|$start_dir" ]; then
+  echo "options should not leak into the session: got '$response'"
+  exit 1
+fi
+
+# a missing working directory fails the command
+code=$(curl -s -D - -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" -H "X-Command-Cwd: /nonexistent" \
+  -d "true" "$BASE_URL/execute" | tr -d '\r' | grep -i "^X-Exit-Code:" | cut -d' ' -f2)
+if [ "$code" == "0" ] || [ -z "$code" ]; then
+  echo "missing working directory should fail the command: got '$code'"
+  exit 1
+fi
+
+# malformed and invalid variables are rejected
+status=$(curl -s -o /dev/null -w "%{http_code}" -X POST -H "X-Shell-Key: $API_KEY" -H "X-Command-Env: GREETING" \
+  -d "true" "$BASE_URL/execute")
+if [ "$status" != "400" ]; then
+  echo "malformed variable should return 400: got $status"
+  exit 1
+fi
+
+status=$(curl -s -o /dev/null -w "%{http_code}" -X POST -H "X-Shell-Key: $API_KEY" -H "X-Command-Env: 1BAD=x" \
+  -d "true" "$BASE_URL/execute")
+if [ "$status" != "400" ]; then
+  echo "invalid variable name should return 400: got $status"
+  exit 1
+fi
+
+exit 0
@@ -56,4 +56,44 @@ if [ "$response" != "echo_n_test" ]; then
   exit 1
 fi
 
+# test exact output preserves blank lines and whitespace
+curl -s -X POST -H "X-Shell-Key: $API_KEY" -H "X-Exact-Output: true" \
+  -d "printf 'a\n\n  b\n'" "$BASE_URL/execute" -o /tmp/shelld_exact_output
+if ! printf 'a\n\n  b\n' | cmp -s - /tmp/shelld_exact_output; then
+  echo "exact output failed: got '$(cat /tmp/shelld_exact_output)'"
+  exit 1
+fi
+rm -f /tmp/shelld_exact_output
+
+response=$(curl -s -X POST -H "X-Shell-Key: $API_KEY" -H "Content-Type: application/json" \
+  -d '{"command":"printf \"x\\n\\ny\"","exact_output":true}' "$BASE_URL/execute")
+if [[ "$response" != *'"output":"x\n\ny"'* ]]; then
+  echo "exact JSON output failed: got '$response'"
+  exit 1
+fi
+
+# test colour codes are stripped by the output filter
+response=$(curl -s -X POST -H "X-Shell-Key: $API_KEY" -H "X-Output-Filter: strip" \
+  -d "printf '\033[32mgreen\033[0m\n'" "$BASE_URL/execute")
+if [ "$response" != "green" ]; then
+  echo "stripped output failed: expected 'green', got '$response'"
+  exit 1
+fi
+
+# test progress redraws collapse when rendered
+response=$(curl -s -X POST -H "X-Shell-Key: $API_KEY" -H "Content-Type: application/json" \
+  -d '{"command":"printf \"10%%\\r50%%\\r100%%\\n\"","output_filter":"render"}' "$BASE_URL/execute")
+if [[ "$response" != *'"output":"100%"'* ]]; then
+  echo "rendered output failed: got '$response'"
+  exit 1
+fi
+
+# test an unknown output filter is rejected
+status=$(curl -s -o /dev/null -w "%{http_code}" -X POST -H "X-Shell-Key: $API_KEY" -H "X-Output-Filter: colour" \
+  -d "echo test" "$BASE_URL/execute")
+if [ "$status" != "400" ]; then
+  echo "unknown output filter should return 400: got $status"
+  exit 1
+fi
+
 exit 0
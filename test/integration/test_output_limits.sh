@@ -0,0 +1,52 @@
+#!/bin/bash
+# test output truncation and fetching the complete output
+# ( the test configuration buffers 65536 bytes and returns 8192 bytes )
+
+BASE_URL="http://localhost:8084"
+API_KEY="test"
+
+# startup
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/lock"
+
+# a large output is truncated to its head and tail
+response=$(curl -s -X POST -H "X-Shell-Key: $API_KEY" -H "Content-Type: application/json" \
+  -d '{"command":"seq 1 100000"}' "$BASE_URL/execute")
+if [[ "$response" != *'"truncated":true'* ]]; then
+  echo "large output should be truncated: got '${response:0:200}'"
+  exit 1
+fi
+if [[ "$response" != *'"output":"1\n2\n3\n'* ]] || [[ "$response" != *'99999\n100000"'* ]]; then
+  echo "truncated output should keep its head and tail: got '${response:0:200}'"
+  exit 1
+fi
+
+size=$(curl -s -H "X-Shell-Key: $API_KEY" "$BASE_URL/output" | wc -c)
+if [ "$size" -gt 8300 ]; then
+  echo "truncated output should be about 8192 bytes: got $size"
+  exit 1
+fi
+
+# raw responses report truncation in a header
+header=$(curl -s -D - -o /dev/null -H "X-Shell-Key: $API_KEY" "$BASE_URL/output" | tr -d '\r' | grep -i "^X-Output-Truncated:")
+if [ "$header" != "X-Output-Truncated: true" ]; then
+  echo "output should have the truncated header: got '$header'"
+  exit 1
+fi
+
+# the complete output is available
+size=$(curl -s -H "X-Shell-Key: $API_KEY" "$BASE_URL/output/full" | wc -c)
+if [ "$size" != "588895" ]; then
+  echo "complete output should be 588895 bytes: got $size"
+  exit 1
+fi
+
+# and can be fetched in ranges
+status=$(curl -s -o /tmp/shelld_output_range -w "%{http_code}" -H "X-Shell-Key: $API_KEY" \
+  -H "Range: bytes=588882-" "$BASE_URL/output/full")
+if [ "$status" != "206" ] || [ "$(cat /tmp/shelld_output_range)" != $'99999\n100000' ]; then
+  echo "range request should return the end of the output: got $status '$(cat /tmp/shelld_output_range)'"
+  exit 1
+fi
+rm -f /tmp/shelld_output_range
+
+exit 0
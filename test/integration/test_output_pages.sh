@@ -0,0 +1,109 @@
+#!/bin/bash
+# test fetching parts of the output by byte range, line range and cursor
+# ( the test configuration returns at most 8192 bytes )
+
+BASE_URL="http://localhost:8084"
+API_KEY="test"
+
+# startup
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/lock"
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" -d "seq 1 100000" "$BASE_URL/execute"
+
+# a byte range
+response=$(curl -s -H "X-Shell-Key: $API_KEY" "$BASE_URL/output?offset=2&length=6")
+if [ "$response" != $'2\n3\n4' ]; then
+  echo "byte range should return '2\\n3\\n4\\n': got '$response'"
+  exit 1
+fi
+
+# a part is capped at the returned bytes limit
+size=$(curl -s -H "X-Shell-Key: $API_KEY" "$BASE_URL/output?offset=0" | wc -c)
+if [ "$size" != "8192" ]; then
+  echo "part should be capped at 8192 bytes: got $size"
+  exit 1
+fi
+
+# a line range
+response=$(curl -s -H "X-Shell-Key: $API_KEY" "$BASE_URL/output?lines=99998-")
+if [ "$response" != $'99998\n99999\n100000' ]; then
+  echo "line range should return the last three lines: got '$response'"
+  exit 1
+fi
+
+response=$(curl -s -H "X-Shell-Key: $API_KEY" -H "Accept: application/json" "$BASE_URL/output?lines=10-11")
+if [ "$response" != '{"job_id":1,"output":"10\n11\n","offset":18,"cursor":24,"size":588895,"complete":true,"state":"locked"}' ]; then
+  echo "line range should report its position: got '$response'"
+  exit 1
+fi
+
+# a Range header
+response=$(curl -s -H "X-Shell-Key: $API_KEY" -H "Range: bytes=0-3" "$BASE_URL/output")
+if [ "$response" != $'1\n2' ]; then
+  echo "Range header should return '1\\n2\\n': got '$response'"
+  exit 1
+fi
+
+# invalid ranges
+status=$(curl -s -o /dev/null -w "%{http_code}" -H "X-Shell-Key: $API_KEY" "$BASE_URL/output?lines=5-2")
+if [ "$status" != "400" ]; then
+  echo "invalid line range should return 400: got $status"
+  exit 1
+fi
+status=$(curl -s -o /dev/null -w "%{http_code}" -H "X-Shell-Key: $API_KEY" "$BASE_URL/output?offset=-1")
+if [ "$status" != "400" ]; then
+  echo "negative offset should return 400: got $status"
+  exit 1
+fi
+
+# a running command is polled with the cursor until it is complete
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" -H "X-Command-Timeout: 100ms" \
+  -d "for i in 1 2 3; do echo line \$i; sleep 0.5; done" "$BASE_URL/execute"
+
+cursor=0
+job=
+complete=false
+polls=0
+rm -f /tmp/shelld_polled
+for i in $(seq 50); do
+  curl -s -D /tmp/shelld_page_headers -o /tmp/shelld_page -H "X-Shell-Key: $API_KEY" "$BASE_URL/output?since=$cursor&job=$job"
+  cat /tmp/shelld_page >> /tmp/shelld_polled
+  job=$(tr -d '\r' < /tmp/shelld_page_headers | grep -i "^X-Job-ID:" | cut -d' ' -f2)
+  cursor=$(tr -d '\r' < /tmp/shelld_page_headers | grep -i "^X-Output-Cursor:" | cut -d' ' -f2)
+  complete=$(tr -d '\r' < /tmp/shelld_page_headers | grep -i "^X-Output-Complete:" | cut -d' ' -f2)
+  if [ "$complete" = "true" ]; then
+    break
+  fi
+  polls=$((polls + 1))
+  sleep 0.2
+done
+output=$(cat /tmp/shelld_polled)
+rm -f /tmp/shelld_page /tmp/shelld_page_headers /tmp/shelld_polled
+
+if [ "$complete" != "true" ] || [ "$polls" -lt 2 ]; then
+  echo "polled command should be running at first and then complete: $polls incomplete polls"
+  exit 1
+fi
+if [ "$output" != $'line 1\nline 2\nline 3' ]; then
+  echo "polled output should be complete: got '$output'"
+  exit 1
+fi
+if [ "$job" != "2" ]; then
+  echo "polled output should belong to job 2: got '$job'"
+  exit 1
+fi
+
+# the cursor of a job does not carry over to the next command
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" -d "echo next" "$BASE_URL/execute"
+response=$(curl -s -w " %{http_code}" -H "X-Shell-Key: $API_KEY" -H "Accept: application/json" \
+  "$BASE_URL/output?since=$cursor&job=$job")
+if [[ "$response" != *'"error":"not_current"'*" 409" ]]; then
+  echo "a page of a previous job should return 409 not_current: got '$response'"
+  exit 1
+fi
+status=$(curl -s -o /dev/null -w "%{http_code}" -H "X-Shell-Key: $API_KEY" "$BASE_URL/output?since=0&job=x")
+if [ "$status" != "400" ]; then
+  echo "invalid job should return 400: got $status"
+  exit 1
+fi
+
+exit 0
@@ -0,0 +1,56 @@
+#!/bin/bash
+# test the process tree
+# the descendants of the shell are listed with their parent, command and state
+
+BASE_URL="http://localhost:8084"
+API_KEY="test"
+
+# the shell must be running
+status=$(curl -s -o /dev/null -w "%{http_code}" -H "X-Shell-Key: $API_KEY" "$BASE_URL/processes/tree")
+if [ "$status" != "409" ]; then
+  echo "tree before lock should return 409: got $status"
+  exit 1
+fi
+
+# startup
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/lock"
+
+# a stuck command shows up under the shell
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" -H "X-Command-Timeout: 1s" \
+  -d "bash -c 'sleep 30; true'" "$BASE_URL/execute"
+sleep 0.5
+response=$(curl -s -H "X-Shell-Key: $API_KEY" "$BASE_URL/processes/tree")
+shell_pid=$(echo "$response" | sed 's/^{"pid":\([0-9]*\),.*/\1/')
+if [[ "$response" != '{"pid":'*'"ppid":'"$shell_pid"',"command":"bash -c sleep 30; true","state":"S"'*'"command":"sleep 30","state":"S","cpu_ms":'*'"rss_bytes":'* ]]; then
+  echo "tree should list the bash and its sleep: got '$response'"
+  exit 1
+fi
+
+# a session has its own shell and tree
+session=$(curl -s -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/sessions" | sed -n 's/.*"id":"\([0-9a-f]*\)".*/\1/p')
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" -d "true" "$BASE_URL/sessions/$session/execute"
+response=$(curl -s -H "X-Shell-Key: $API_KEY" "$BASE_URL/sessions/$session/processes/tree")
+if [[ "$response" != '{"pid":'*',"processes":['* ]] || [[ "$response" == '{"pid":'"$shell_pid"','* ]]; then
+  echo "session tree should start at its own shell: got '$response'"
+  exit 1
+fi
+curl -s -o /dev/null -X DELETE -H "X-Shell-Key: $API_KEY" "$BASE_URL/sessions/$session"
+
+# a background process cannot be named after the tree
+status=$(curl -s -o /dev/null -w "%{http_code}" -X POST -H "X-Shell-Key: $API_KEY" -d "true" "$BASE_URL/processes?name=tree")
+if [ "$status" != "400" ]; then
+  echo "reserved name should return 400: got $status"
+  exit 1
+fi
+
+# once killed, the sleep is gone
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/kill"
+sleep 0.5
+response=$(curl -s -H "X-Shell-Key: $API_KEY" "$BASE_URL/processes/tree")
+if [[ "$response" == *'"command":"sleep 30"'* ]]; then
+  echo "tree should no longer list the sleep: got '$response'"
+  exit 1
+fi
+
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/unlock"
+exit 0
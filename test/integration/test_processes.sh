@@ -0,0 +1,83 @@
+#!/bin/bash
+# test background processes
+# named processes run next to the session, their output is kept and they are stopped on request
+
+BASE_URL="http://localhost:8084"
+API_KEY="test"
+
+# startup
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/lock"
+
+# a process starts in the session's working directory
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" -d "cd /tmp" "$BASE_URL/execute"
+response=$(curl -s -w " %{http_code}" -X POST -H "X-Shell-Key: $API_KEY" \
+  -d 'pwd; for i in 1 2 3; do echo "line $i"; done; sleep 30' "$BASE_URL/processes?name=server")
+if [[ "$response" != '{"name":"server",'*'"status":"running"'*" 201" ]]; then
+  echo "process should start: got '$response'"
+  exit 1
+fi
+pid=$(echo "$response" | sed 's/.*"pid":\([0-9]*\).*/\1/')
+
+# the name cannot be reused while the process runs
+status=$(curl -s -o /dev/null -w "%{http_code}" -X POST -H "X-Shell-Key: $API_KEY" -d "true" "$BASE_URL/processes?name=server")
+if [ "$status" != "409" ]; then
+  echo "duplicate process should return 409: got $status"
+  exit 1
+fi
+
+status=$(curl -s -o /dev/null -w "%{http_code}" -X POST -H "X-Shell-Key: $API_KEY" -d "true" "$BASE_URL/processes?name=../x")
+if [ "$status" != "400" ]; then
+  echo "invalid name should return 400: got $status"
+  exit 1
+fi
+
+# a JSON request carries the process in the body
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" -H "Content-Type: application/json" \
+  -d '{"name":"job","command":"exit 4"}' "$BASE_URL/processes"
+
+sleep 0.5
+response=$(curl -s -H "X-Shell-Key: $API_KEY" "$BASE_URL/processes")
+if [[ "$response" != '[{"name":"server",'*'"status":"running"'*'{"name":"job",'*'"status":"exited"'*'"exit_code":4}]' ]]; then
+  echo "processes should be listed: got '$response'"
+  exit 1
+fi
+
+# the end of the output is available
+response=$(curl -s -H "X-Shell-Key: $API_KEY" "$BASE_URL/processes/server/output")
+if [ "$response" != $'/tmp\nline 1\nline 2\nline 3' ]; then
+  echo "output should be captured: got '$response'"
+  exit 1
+fi
+response=$(curl -s -H "X-Shell-Key: $API_KEY" "$BASE_URL/processes/server/output?lines=2")
+if [ "$response" != $'line 2\nline 3' ]; then
+  echo "output should be limited to the last lines: got '$response'"
+  exit 1
+fi
+
+# stopping ends the process and removes it
+response=$(curl -s -X DELETE -H "X-Shell-Key: $API_KEY" "$BASE_URL/processes/server")
+if [[ "$response" != *'"status":"exited"'*'"exit_code":143}' ]]; then
+  echo "process should be stopped: got '$response'"
+  exit 1
+fi
+if kill -0 "$pid" 2>/dev/null; then
+  echo "process $pid should be gone"
+  exit 1
+fi
+status=$(curl -s -o /dev/null -w "%{http_code}" -H "X-Shell-Key: $API_KEY" "$BASE_URL/processes/server")
+if [ "$status" != "404" ]; then
+  echo "stopped process should return 404: got $status"
+  exit 1
+fi
+
+# unlocking the shell stops the remaining processes
+response=$(curl -s -X POST -H "X-Shell-Key: $API_KEY" -d "sleep 30" "$BASE_URL/processes?name=left")
+pid=$(echo "$response" | sed 's/.*"pid":\([0-9]*\).*/\1/')
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/unlock"
+sleep 0.5
+if kill -0 "$pid" 2>/dev/null; then
+  echo "process $pid should be stopped on unlock"
+  exit 1
+fi
+
+exit 0
@@ -0,0 +1,76 @@
+#!/bin/bash
+# test automatic restart
+# by default a shell that dies while running a command leaves the server unrecoverable; with
+# auto_restart it is replaced and the init script is replayed
+
+BASE_URL="http://localhost:8084"
+API_KEY="test"
+ROOT="$(cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd)"
+
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/lock"
+
+response=$(curl -s -w " %{http_code}" -X POST -H "X-Shell-Key: $API_KEY" -H "Accept: application/json" \
+  -d 'kill -9 $$' "$BASE_URL/execute")
+if [[ "$response" != *'"error":"unrecoverable"'* ]] || [[ "$response" != *" 409" ]]; then
+  echo "killed shell should be unrecoverable without auto_restart: got '$response'"
+  exit 1
+fi
+
+response=$(curl -s -H "X-Shell-Key: $API_KEY" "$BASE_URL/state")
+if [ "$response" != "unrecoverable" ]; then
+  echo "state should be 'unrecoverable' without auto_restart: got '$response'"
+  exit 1
+fi
+
+# a server with auto_restart and an init script
+BASE_URL="http://localhost:8086"
+"$ROOT/bin/shelld" --config "$ROOT/config/config.test.restart.toml" > /dev/null 2>&1 &
+restart_pid=$!
+trap 'kill $restart_pid 2>/dev/null' EXIT
+for i in $(seq 50); do curl -s -o /dev/null "$BASE_URL/health" && break; sleep 0.1; done
+
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/lock"
+
+# the init script runs when the shell starts
+response=$(curl -s -X POST -H "X-Shell-Key: $API_KEY" -d 'echo $SHELLD_INIT' "$BASE_URL/execute")
+if [ "$response" != "done" ]; then
+  echo "init script should have run: got '$response'"
+  exit 1
+fi
+
+# the command that kills the shell reports the restart
+response=$(curl -s -w " %{http_code}" -X POST -H "X-Shell-Key: $API_KEY" -H "Accept: application/json" \
+  -d 'kill -9 $$' "$BASE_URL/execute")
+if [[ "$response" != *'"error":"restarted"'* ]] || [[ "$response" != *'"state":"locked"'* ]] || [[ "$response" != *" 409" ]]; then
+  echo "killed shell should be restarted: got '$response'"
+  exit 1
+fi
+
+response=$(curl -s -H "X-Shell-Key: $API_KEY" "$BASE_URL/state")
+if [ "$response" != "locked" ]; then
+  echo "state should be 'locked' after the restart: got '$response'"
+  exit 1
+fi
+
+# the same key keeps working and the init script was replayed
+response=$(curl -s -X POST -H "X-Shell-Key: $API_KEY" -d 'echo $SHELLD_INIT' "$BASE_URL/execute")
+if [ "$response" != "done" ]; then
+  echo "init script should have been replayed: got '$response'"
+  exit 1
+fi
+
+# a streamed command reports the restart as an event
+response=$(curl -s -N -X POST -H "X-Shell-Key: $API_KEY" -H "Accept: text/event-stream" \
+  -d 'kill -9 $$' "$BASE_URL/execute")
+if [[ "$response" != *"event: restarted"* ]]; then
+  echo "stream should report the restart: got '$response'"
+  exit 1
+fi
+
+response=$(curl -s -X POST -H "X-Shell-Key: $API_KEY" -d "echo still_alive" "$BASE_URL/execute")
+if [ "$response" != "still_alive" ]; then
+  echo "shell should work after the restart: got '$response'"
+  exit 1
+fi
+
+exit 0
@@ -0,0 +1,34 @@
+#!/bin/bash
+# test process limits
+# the limits from [shell.rlimits] apply to the shell, its commands and background processes
+
+BASE_URL="http://localhost:8087"
+API_KEY="test"
+ROOT="$(cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd)"
+
+# a server with process limits
+"$ROOT/bin/shelld" --config "$ROOT/config/config.test.rlimits.toml" > /dev/null 2>&1 &
+rlimits_pid=$!
+trap 'kill $rlimits_pid 2>/dev/null' EXIT
+for i in $(seq 50); do curl -s -o /dev/null "$BASE_URL/health" && break; sleep 0.1; done
+
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/lock"
+
+# the soft and the hard limit are set
+response=$(curl -s -X POST -H "X-Shell-Key: $API_KEY" -d "ulimit -Sn; ulimit -Hn" "$BASE_URL/execute")
+if [ "$response" != $'4096\n4096' ]; then
+  echo "shell should have 4096 open files: got '$response'"
+  exit 1
+fi
+
+# a background process gets the same limits
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" -d "ulimit -n" "$BASE_URL/processes?name=limits"
+sleep 0.5
+response=$(curl -s -H "X-Shell-Key: $API_KEY" "$BASE_URL/processes/limits/output")
+if [ "$response" != "4096" ]; then
+  echo "background process should have 4096 open files: got '$response'"
+  exit 1
+fi
+
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/unlock"
+exit 0
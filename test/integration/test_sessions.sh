@@ -0,0 +1,104 @@
+#!/bin/bash
+# test named sessions
+
+BASE_URL="http://localhost:8084"
+API_KEY="test"
+ROOT="$(cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd)"
+
+session_id() {
+  sed -n 's/.*"id":"\([0-9a-f]*\)".*/\1/p'
+}
+
+# sessions belong to the key the server is locked to
+status=$(curl -s -o /dev/null -w "%{http_code}" -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/sessions")
+if [ "$status" != "409" ]; then
+  echo "sessions should require a locked server: got $status"
+  exit 1
+fi
+
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/lock"
+
+status=$(curl -s -o /dev/null -w "%{http_code}" -X POST -H "X-Shell-Key: other" "$BASE_URL/sessions")
+if [ "$status" != "401" ]; then
+  echo "another key should not create sessions: got $status"
+  exit 1
+fi
+
+first=$(curl -s -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/sessions" | session_id)
+second=$(curl -s -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/sessions" | session_id)
+if [ -z "$first" ] || [ -z "$second" ]; then
+  echo "sessions should be created: got '$first' and '$second'"
+  exit 1
+fi
+
+# state is kept per session
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" -d "export SESSION_VAR=first" \
+  "$BASE_URL/sessions/$first/execute"
+response=$(curl -s -X POST -H "X-Shell-Key: $API_KEY" -d 'echo ${SESSION_VAR:-unset}' \
+  "$BASE_URL/sessions/$first/execute")
+if [ "$response" != "first" ]; then
+  echo "session should keep its state: expected 'first', got '$response'"
+  exit 1
+fi
+response=$(curl -s -X POST -H "X-Shell-Key: $API_KEY" -d 'echo ${SESSION_VAR:-unset}' \
+  "$BASE_URL/sessions/$second/execute")
+if [ "$response" != "unset" ]; then
+  echo "sessions should be isolated: expected 'unset', got '$response'"
+  exit 1
+fi
+
+response=$(curl -s -H "X-Shell-Key: $API_KEY" "$BASE_URL/sessions/$first/state")
+if [ "$response" != "locked" ]; then
+  echo "session state should be 'locked': got '$response'"
+  exit 1
+fi
+
+# the locked key is required
+status=$(curl -s -o /dev/null -w "%{http_code}" -H "X-Shell-Key: other" "$BASE_URL/sessions/$first/state")
+if [ "$status" != "401" ]; then
+  echo "wrong key should return 401: got $status"
+  exit 1
+fi
+
+# deleted sessions are gone
+status=$(curl -s -o /dev/null -w "%{http_code}" -X DELETE -H "X-Shell-Key: $API_KEY" "$BASE_URL/sessions/$first")
+if [ "$status" != "200" ]; then
+  echo "delete should return 200: got $status"
+  exit 1
+fi
+status=$(curl -s -o /dev/null -w "%{http_code}" -H "X-Shell-Key: $API_KEY" "$BASE_URL/sessions/$first/state")
+if [ "$status" != "404" ]; then
+  echo "deleted session should return 404: got $status"
+  exit 1
+fi
+
+response=$(curl -s -H "X-Shell-Key: $API_KEY" "$BASE_URL/sessions")
+if echo "$response" | grep -q "$first" || ! echo "$response" | grep -q "$second"; then
+  echo "session list should only contain the remaining session: got '$response'"
+  exit 1
+fi
+
+# a recycling unlock closes the sessions with the shell
+RECYCLE_URL="http://localhost:8085"
+"$ROOT/bin/shelld" --config "$ROOT/config/config.test.recycle.toml" > /dev/null 2>&1 &
+recycle_pid=$!
+trap 'kill $recycle_pid 2>/dev/null' EXIT
+for i in $(seq 50); do curl -s -o /dev/null "$RECYCLE_URL/health" && break; sleep 0.1; done
+
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" "$RECYCLE_URL/lock"
+recycled=$(curl -s -X POST -H "X-Shell-Key: $API_KEY" "$RECYCLE_URL/sessions" | session_id)
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" "$RECYCLE_URL/unlock"
+
+curl -s -o /dev/null -X POST -H "X-Shell-Key: other" "$RECYCLE_URL/lock"
+response=$(curl -s -H "X-Shell-Key: other" "$RECYCLE_URL/sessions")
+if [ -z "$recycled" ] || [ "$response" != "[]" ]; then
+  echo "recycling should close the sessions: created '$recycled', then listed '$response'"
+  exit 1
+fi
+status=$(curl -s -o /dev/null -w "%{http_code}" -H "X-Shell-Key: $API_KEY" "$RECYCLE_URL/sessions/$recycled/state")
+if [ "$status" != "401" ]; then
+  echo "the previous key should be rejected after recycling: got $status"
+  exit 1
+fi
+
+exit 0
@@ -0,0 +1,39 @@
+#!/bin/bash
+# test the state document
+# /state reports the session as the last command left it and the running command
+
+BASE_URL="http://localhost:8084"
+API_KEY="test"
+
+# startup
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/lock"
+
+# the plain state is still the state name
+response=$(curl -s -H "X-Shell-Key: $API_KEY" "$BASE_URL/state")
+if [ "$response" != "locked" ]; then
+  echo "state should be 'locked': got '$response'"
+  exit 1
+fi
+
+# the working directory and variables follow the session
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" -d "cd /tmp; export PATH=/opt/state:\$PATH; false" "$BASE_URL/execute"
+response=$(curl -s -H "X-Shell-Key: $API_KEY" -H "Accept: application/json" "$BASE_URL/state")
+if [[ "$response" != *'"cwd":"/tmp"'* ]] || [[ "$response" != *'"PATH":"/opt/state:'* ]]; then
+  echo "state should report the session: got '$response'"
+  exit 1
+fi
+if [[ "$response" != *'"pid":'* ]] || [[ "$response" != *'"last_job_id":1,"last_exit_code":1'* ]]; then
+  echo "state should report the shell and the last command: got '$response'"
+  exit 1
+fi
+
+# the running command is reported with its elapsed time
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" -d "sleep 1" "$BASE_URL/execute?async=true"
+sleep 0.3
+response=$(curl -s -H "X-Shell-Key: $API_KEY" -H "Accept: application/json" "$BASE_URL/state")
+if [[ "$response" != *'"command":{"job_id":2,"command":"sleep 1","elapsed_ms":'* ]]; then
+  echo "state should report the running command: got '$response'"
+  exit 1
+fi
+
+exit 0
@@ -21,4 +21,11 @@ if [[ "$response" != *"already locked"* ]]; then
   exit 1
 fi
 
+# the error is JSON when asked for
+response=$(curl -s -X POST -H "X-Shell-Key: $API_KEY" -H "Accept: application/json" "$BASE_URL/lock")
+if [[ "$response" != *'"error":"locked"'* ]] || [[ "$response" != *'"state":"locked"'* ]]; then
+  echo "double lock should return the JSON error: got '$response'"
+  exit 1
+fi
+
 exit 0
@@ -0,0 +1,50 @@
+#!/bin/bash
+# test streaming command output over Server-Sent Events
+
+BASE_URL="http://localhost:8084"
+API_KEY="test"
+
+# startup
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/lock"
+
+# streaming execute emits output events and a final exit event
+response=$(curl -s -N -X POST \
+  -H "X-Shell-Key: $API_KEY" \
+  -H "Accept: text/event-stream" \
+  -d 'echo first; sleep 0.5; echo second; (exit 3)' \
+  "$BASE_URL/execute")
+if ! echo "$response" | grep -q 'event: output'; then
+  echo "stream should contain output events: got '$response'"
+  exit 1
+fi
+if ! echo "$response" | grep -q 'first' || ! echo "$response" | grep -q 'second'; then
+  echo "stream should contain the command output: got '$response'"
+  exit 1
+fi
+if echo "$response" | grep -q 'SHELLD'; then
+  echo "stream should not contain markers: got '$response'"
+  exit 1
+fi
+if ! echo "$response" | grep -q '"exit_code":3'; then
+  echo "stream should end with the exit code: got '$response'"
+  exit 1
+fi
+
+# a command that went 202 can be followed until it completes
+curl -s -o /dev/null -X POST \
+  -H "X-Shell-Key: $API_KEY" \
+  -H "X-Command-Timeout: 500ms" \
+  -d "echo started; sleep 2; echo finished" \
+  "$BASE_URL/execute"
+
+response=$(curl -s -N -H "X-Shell-Key: $API_KEY" "$BASE_URL/output/stream")
+if ! echo "$response" | grep -q 'started' || ! echo "$response" | grep -q 'finished'; then
+  echo "follower should receive the whole output: got '$response'"
+  exit 1
+fi
+if ! echo "$response" | grep -q 'event: exit'; then
+  echo "follower should receive the exit event: got '$response'"
+  exit 1
+fi
+
+exit 0
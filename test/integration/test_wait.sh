@@ -0,0 +1,58 @@
+#!/bin/bash
+# test long-polling for command completion
+
+BASE_URL="http://localhost:8084"
+API_KEY="test"
+
+# startup
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" "$BASE_URL/lock"
+
+# without a command there is nothing to wait for
+status=$(curl -s -o /dev/null -w "%{http_code}" -H "X-Shell-Key: $API_KEY" "$BASE_URL/wait")
+if [ "$status" != "404" ]; then
+  echo "wait without a command should return 404: got $status"
+  exit 1
+fi
+
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" -d "sleep 1; echo waited; false" "$BASE_URL/execute?async=true"
+
+# the wait times out while the command is running
+status=$(curl -s -o /dev/null -w "%{http_code}" -H "X-Shell-Key: $API_KEY" "$BASE_URL/wait?timeout=100ms")
+if [ "$status" != "202" ]; then
+  echo "wait should return 202 while the command is running: got $status"
+  exit 1
+fi
+
+# the wait returns once the command completes
+response=$(curl -s -D /tmp/shelld_wait_headers -H "X-Shell-Key: $API_KEY" "$BASE_URL/wait?timeout=10s")
+exit_code=$(tr -d '\r' < /tmp/shelld_wait_headers | grep -i "^X-Exit-Code:" | cut -d' ' -f2)
+rm -f /tmp/shelld_wait_headers
+if [ "$response" != "waited" ] || [ "$exit_code" != "1" ]; then
+  echo "wait should return the output and exit code: got '$response' $exit_code"
+  exit 1
+fi
+
+# JSON callers get the execute response
+response=$(curl -s -H "X-Shell-Key: $API_KEY" -H "Accept: application/json" "$BASE_URL/wait")
+if [[ "$response" != '{"job_id":1,"output":"waited","exit_code":1,'* ]]; then
+  echo "wait should return the JSON result: got '$response'"
+  exit 1
+fi
+
+# separated stderr is reported even when it is empty, as /execute does
+curl -s -o /dev/null -X POST -H "X-Shell-Key: $API_KEY" -H "Content-Type: application/json" \
+  -d '{"command":"echo quiet","separate_stderr":true}' "$BASE_URL/execute?async=true"
+response=$(curl -s -H "X-Shell-Key: $API_KEY" -H "Accept: application/json" "$BASE_URL/wait?timeout=10s")
+if [[ "$response" != *'"output":"quiet"'* ]] || [[ "$response" != *'"stderr":""'* ]]; then
+  echo "wait should return the empty separated stderr: got '$response'"
+  exit 1
+fi
+
+# an invalid timeout is rejected
+status=$(curl -s -o /dev/null -w "%{http_code}" -H "X-Shell-Key: $API_KEY" "$BASE_URL/wait?timeout=soon")
+if [ "$status" != "400" ]; then
+  echo "invalid timeout should return 400: got $status"
+  exit 1
+fi
+
+exit 0